@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type aggregation struct {
+	Place  string    `json:"place"`
+	Bucket string    `json:"bucket"` // 2022-01-03 / 2022-W01 / 2022-01 / 2022-Q1 / 2022
+	Begin  time.Time `json:"begin"`
+	End    time.Time `json:"end"`
+	Value  float64   `json:"value"`
+	Days   int       `json:"days"` // 集計に使った日数
+}
+
+var aggregateBuckets = []string{"day", "week", "isoweek", "month", "quarter", "year"}
+
+var aggregateFuncs = []string{"sum", "last", "mean", "max"}
+
+// -------------
+// 期間・都道府県を指定して集計
+// -------------
+
+// sum: 新規感染者数の合計, last: 期間末の累計, mean: 新規感染者数の平均, max: 新規感染者数の最大
+func Aggregate(c *gin.Context) {
+	db, err := database()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	bucket, err := parseEnum("bucket", c.DefaultQuery("bucket", "week"), aggregateBuckets)
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
+	}
+	fn, err := parseEnum("func", c.DefaultQuery("func", "sum"), aggregateFuncs)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	from, to, err := parseDateRange("from", c.Query("from"), "to", c.Query("to"), maxPlaceRangeDays)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	// places を省略した場合は47都道府県
+	prefs, err := parsePlaces("places", c.Query("places"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	var places []string
+	for _, pref := range prefs {
+		places = append(places, pref.NameJp)
+	}
+
+	cumulative, err := queryCumulative(c.Request.Context(), db, places, from.AddDate(0, 0, -1), to)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	// combine=true の場合は指定した都道府県を合算して1系列にする
+	if c.Query("combine") == "true" {
+		cumulative = map[string][]seriesPoint{"合計": combineSeries(places, cumulative)}
+		places = []string{"合計"}
+	}
+
+	result := []aggregation{}
+	for _, place := range places {
+		result = append(result, aggregate(place, cumulative[place], from, bucket, fn)...)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// 複数の都道府県の累計感染者数をまとめて取得する
+func queryCumulative(ctx context.Context, db *sql.DB, places []string, from, to time.Time) (map[string][]seriesPoint, error) {
+	args := []interface{}{from, to}
+	for _, place := range places {
+		args = append(args, place)
+	}
+	query := "select name_jp, date, npatients from infection where date between ? and ? and name_jp in (?" + strings.Repeat(",?", len(places)-1) + ") order by date ASC"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := map[string][]seriesPoint{}
+	for rows.Next() {
+		var place string
+		var p seriesPoint
+		if err := rows.Scan(&place, &p.Date, &p.Value); err != nil {
+			return nil, err
+		}
+		result[place] = append(result[place], p)
+	}
+	return result, rows.Err()
+}
+
+// 日付ごとに合算する (いずれかの都道府県でデータが欠けている日は除く)
+func combineSeries(places []string, series map[string][]seriesPoint) []seriesPoint {
+	sums := map[time.Time]float64{}
+	counts := map[time.Time]int{}
+	var dates []time.Time
+	for _, place := range places {
+		for _, p := range series[place] {
+			if counts[p.Date] == 0 {
+				dates = append(dates, p.Date)
+			}
+			sums[p.Date] += p.Value
+			counts[p.Date]++
+		}
+	}
+
+	var result []seriesPoint
+	for _, date := range sortedDates(dates) {
+		if counts[date] == len(places) {
+			result = append(result, seriesPoint{Date: date, Value: sums[date]})
+		}
+	}
+	return result
+}
+
+// 累計の系列 (from の前日から) をバケットごとに集計する
+func aggregate(place string, cumulative []seriesPoint, from time.Time, bucket, fn string) []aggregation {
+	values := dailySeries(cumulative, from)
+	if fn == "last" {
+		values = nil
+		for _, p := range cumulative {
+			if !p.Date.Before(from) {
+				values = append(values, p)
+			}
+		}
+	}
+
+	var result []aggregation
+	for _, p := range values {
+		begin, end, label := bucketOf(p.Date, bucket)
+		if len(result) == 0 || result[len(result)-1].Bucket != label {
+			result = append(result, aggregation{Place: place, Bucket: label, Begin: begin, End: end})
+		}
+		a := &result[len(result)-1]
+		a.Days++
+		switch fn {
+		case "sum", "mean":
+			a.Value += p.Value
+		case "last":
+			a.Value = p.Value
+		case "max":
+			if a.Days == 1 || p.Value > a.Value {
+				a.Value = p.Value
+			}
+		}
+	}
+
+	if fn == "mean" {
+		for i := range result {
+			result[i].Value /= float64(result[i].Days)
+		}
+	}
+	return result
+}
+
+// 日付が属するバケットの初日・最終日・ラベルを返す
+func bucketOf(t time.Time, bucket string) (time.Time, time.Time, string) {
+	switch bucket {
+	case "week":
+		// 日曜始まり
+		begin := t.AddDate(0, 0, -int(t.Weekday()))
+		return begin, begin.AddDate(0, 0, 6), begin.Format("2006-01-02")
+	case "isoweek":
+		// 月曜始まり、ISO 8601 の年と週番号
+		begin := t.AddDate(0, 0, -(int(t.Weekday())+6)%7)
+		year, week := t.ISOWeek()
+		return begin, begin.AddDate(0, 0, 6), fmt.Sprintf("%d-W%02d", year, week)
+	case "month":
+		begin := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+		return begin, begin.AddDate(0, 1, -1), begin.Format("2006-01")
+	case "quarter":
+		q := (int(t.Month()) - 1) / 3
+		begin := time.Date(t.Year(), time.Month(q*3+1), 1, 0, 0, 0, 0, t.Location())
+		return begin, begin.AddDate(0, 3, -1), fmt.Sprintf("%d-Q%d", t.Year(), q+1)
+	case "year":
+		begin := time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+		return begin, begin.AddDate(1, 0, -1), begin.Format("2006")
+	default:
+		return t, t, t.Format("2006-01-02")
+	}
+}
+
+func sortedDates(dates []time.Time) []time.Time {
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	return dates
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBucketOf(t *testing.T) {
+	// 2021-01-03 は日曜日、ISO週では 2020-W53
+	date := time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		bucket, label, begin, end string
+	}{
+		{"day", "2021-01-03", "2021-01-03", "2021-01-03"},
+		{"week", "2021-01-03", "2021-01-03", "2021-01-09"},
+		{"isoweek", "2020-W53", "2020-12-28", "2021-01-03"},
+		{"month", "2021-01", "2021-01-01", "2021-01-31"},
+		{"quarter", "2021-Q1", "2021-01-01", "2021-03-31"},
+		{"year", "2021", "2021-01-01", "2021-12-31"},
+	}
+	for _, tt := range tests {
+		begin, end, label := bucketOf(date, tt.bucket)
+		if label != tt.label || begin.Format("2006-01-02") != tt.begin || end.Format("2006-01-02") != tt.end {
+			t.Errorf("%s: got %s %s-%s, want %s %s-%s", tt.bucket, label, begin.Format("2006-01-02"), end.Format("2006-01-02"), tt.label, tt.begin, tt.end)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	// 2022-01-03 (月) から 2022-01-12 (水) までの累計
+	from := time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC)
+	cumulative := testSeries(from.AddDate(0, 0, -1), 100, 110, 130, 160, 200, 250, 310, 380, 460, 550, 650)
+
+	tests := []struct {
+		fn   string
+		want []float64
+	}{
+		{"sum", []float64{280, 270}},
+		{"last", []float64{380, 650}},
+		{"mean", []float64{40, 90}},
+		{"max", []float64{70, 100}},
+	}
+	for _, tt := range tests {
+		result := aggregate("東京都", cumulative, from, "isoweek", tt.fn)
+		if len(result) != len(tt.want) {
+			t.Fatalf("%s: expected %d buckets, got %d", tt.fn, len(tt.want), len(result))
+		}
+		for i, want := range tt.want {
+			if result[i].Value != want {
+				t.Errorf("%s: expected %v in %s, got %v", tt.fn, want, result[i].Bucket, result[i].Value)
+			}
+		}
+	}
+}
+
+func TestCombineSeries(t *testing.T) {
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	series := map[string][]seriesPoint{
+		"東京都": testSeries(from, 10, 20, 30),
+		"大阪府": testSeries(from, 1, 2),
+	}
+
+	combined := combineSeries([]string{"東京都", "大阪府"}, series)
+	if len(combined) != 2 {
+		t.Fatalf("Expected 2 points, got %d", len(combined))
+	}
+	if combined[0].Value != 11 || combined[1].Value != 22 {
+		t.Errorf("unexpected values %v", combined)
+	}
+}
+
+func TestAggregateInvalidParams(t *testing.T) {
+	r := gin.New()
+	r.GET("/aggregate", Aggregate)
+
+	for _, path := range []string{
+		"/aggregate?from=2022-01-01&to=2022-01-31&bucket=decade",
+		"/aggregate?from=2022-01-01&to=2022-01-31&func=median",
+		"/aggregate?from=2022-01&to=2022-01-31",
+		"/aggregate?from=2022-02-01&to=2022-01-31",
+		"/aggregate?from=2022-01-01&to=2022-01-31&places=Atlantis",
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", path, http.StatusBadRequest, w.Code)
+		}
+	}
+}
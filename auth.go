@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const principalKey = "principal"
+
+// -------------
+// 権限
+// -------------
+
+// 上位の権限は下位の権限を含む
+type role int
+
+const (
+	roleReader role = iota + 1 // 参照のみ (AUTH_REQUIRE_READ=true のときに必要)
+	roleEditor                 // コロナに関するメモの追加・変更・削除
+	roleAdmin                  // データの取り込み
+)
+
+var roleNames = map[role]string{roleReader: "reader", roleEditor: "editor", roleAdmin: "admin"}
+
+func (r role) String() string {
+	return roleNames[r]
+}
+
+func parseRole(s string) (role, error) {
+	for r, name := range roleNames {
+		if name == s {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q", s)
+}
+
+// 認証されたクライアント
+type principal struct {
+	Name string // キーの名前または JWT の sub
+	Role role
+}
+
+// -------------
+// 認証の設定
+// -------------
+
+type authConfig struct {
+	keys        map[string]principal // API キーの SHA-256 (16進) → クライアント。nil なら api_keys テーブルを使う
+	jwtSecret   []byte               // HS256 の署名鍵。nil なら JWT は受け付けない
+	requireRead bool                 // 参照にも reader 以上を必須にする (社外公開時)
+}
+
+var auth authConfig
+
+// キーファイルの1件。key_sha256 は `printf %s "$KEY" | sha256sum` の値
+type apiKeyEntry struct {
+	Name      string `json:"name"`
+	KeySHA256 string `json:"key_sha256"`
+	Role      string `json:"role"`
+}
+
+// 環境変数から読み込む
+//   - AUTH_KEYS_FILE: API キーの JSON ファイル (省略時は DB の api_keys テーブル)
+//   - JWT_SECRET: JWT (HS256) の署名鍵
+//   - AUTH_REQUIRE_READ: true なら参照にも API キーか JWT を必須にする
+func configureAuth() error {
+	config := authConfig{requireRead: os.Getenv("AUTH_REQUIRE_READ") == "true"}
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		config.jwtSecret = []byte(secret)
+	}
+	if path := os.Getenv("AUTH_KEYS_FILE"); path != "" {
+		keys, err := loadKeysFile(path)
+		if err != nil {
+			return err
+		}
+		config.keys = keys
+	}
+	auth = config
+	return nil
+}
+
+func loadKeysFile(path string) (map[string]principal, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []apiKeyEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	keys := map[string]principal{}
+	for _, e := range entries {
+		r, err := parseRole(e.Role)
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %q: %w", path, e.Name, err)
+		}
+		hash := strings.ToLower(e.KeySHA256)
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("%s: key %q: key_sha256 must be a hex encoded SHA-256", path, e.Name)
+		}
+		keys[hash] = principal{Name: e.Name, Role: r}
+	}
+	return keys, nil
+}
+
+// キーそのものは保存しない
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// -------------
+// 認証・認可
+// -------------
+
+// 認証不要のルート (AUTH_REQUIRE_READ=true でも公開する)
+var publicRoutes = map[string]bool{
+	"/":                    true,
+	"/dashboard/*filepath": true,
+	"/openapi.json":        true,
+	"/docs":                true,
+	"/healthz":             true,
+	"/readyz":              true,
+}
+
+// X-API-Key または Authorization: Bearer <JWT> を検証する。
+// 認証情報が不正なら 401、AUTH_REQUIRE_READ=true で認証情報がなければ 401 にする
+func authMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, err := authenticate(c)
+		if err != nil {
+			var e *apiError
+			if errors.As(err, &e) && e.Status == http.StatusUnauthorized {
+				c.Header("WWW-Authenticate", `Bearer realm="corona"`)
+			}
+			abortWithError(c, err) // キーを確認できない (DB のエラー) 場合は 500
+			return
+		}
+		if p != nil {
+			c.Set(principalKey, *p)
+		} else if auth.requireRead && c.FullPath() != "" && !publicRoutes[c.FullPath()] {
+			abortUnauthorized(c, errors.New("no credentials"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// 書き込みのルートに付ける。r 以上の権限がなければ 401 / 403 にする
+func requireRole(r role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v, ok := c.Get(principalKey)
+		if !ok {
+			abortUnauthorized(c, errors.New("no credentials"))
+			return
+		}
+		if p := v.(principal); p.Role < r {
+			abortWithError(c, errForbidden(r, p))
+			return
+		}
+		c.Next()
+	}
+}
+
+func abortUnauthorized(c *gin.Context, err error) {
+	c.Header("WWW-Authenticate", `Bearer realm="corona"`)
+	abortWithError(c, errUnauthorized(err))
+}
+
+// 認証情報がなければ nil を返す。認証情報が不正な場合は 401 の apiError を返す
+func authenticate(c *gin.Context) (*principal, error) {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return lookupAPIKey(c.Request.Context(), key)
+	}
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		return verifyJWT(token)
+	}
+	return nil, nil
+}
+
+// 存在しないキーの結果もキャッシュし、不正なキーで DB に負荷がかからないようにする
+var apiKeyCache = newResultCache(1024, time.Minute)
+
+// AUTH_KEYS_FILE を指定しない場合に使う API キーのテーブルを作る (起動時に1度だけ)
+func migrateAPIKeys(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "create table if not exists api_keys (name varchar(64) not null, key_sha256 char(64) primary key, role varchar(16) not null)")
+	return err
+}
+
+func lookupAPIKey(ctx context.Context, key string) (*principal, error) {
+	hash := hashAPIKey(key)
+	if auth.keys != nil {
+		if p, ok := auth.keys[hash]; ok {
+			return &p, nil
+		}
+		return nil, errUnauthorized(errors.New("unknown API key"))
+	}
+
+	if v, ok := apiKeyCache.Get(hash); ok {
+		if p := v.(principal); p.Role != 0 {
+			return &p, nil
+		}
+		return nil, errUnauthorized(errors.New("unknown API key"))
+	}
+
+	db, err := database()
+	if err != nil {
+		return nil, err
+	}
+
+	var p principal
+	var roleName string
+	err = db.QueryRowContext(ctx, "select name, role from api_keys where key_sha256 = ?", hash).Scan(&p.Name, &roleName)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		apiKeyCache.Add(hash, nil, 0, principal{})
+		return nil, errUnauthorized(errors.New("unknown API key"))
+	case err != nil:
+		return nil, err
+	}
+	if p.Role, err = parseRole(roleName); err != nil {
+		return nil, err
+	}
+	apiKeyCache.Add(hash, nil, 0, p)
+	return &p, nil
+}
+
+// JWT の claims (role は reader / editor / admin)
+type tokenClaims struct {
+	Role string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+func verifyJWT(token string) (*principal, error) {
+	if auth.jwtSecret == nil {
+		return nil, errUnauthorized(errors.New("JWT is not enabled"))
+	}
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return auth.jwtSecret, nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired(), jwt.WithLeeway(30*time.Second))
+	if err != nil {
+		return nil, errUnauthorized(err)
+	}
+	r, err := parseRole(claims.Role)
+	if err != nil {
+		return nil, errUnauthorized(err)
+	}
+	return &principal{Name: claims.Subject, Role: r}, nil
+}
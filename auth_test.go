@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var testJWTSecret = []byte("test-secret")
+
+// テストの間だけ認証の設定を差し替える
+func withAuth(t *testing.T, config authConfig) {
+	t.Helper()
+	saved := auth
+	auth = config
+	t.Cleanup(func() { auth = saved })
+}
+
+func testAuthConfig() authConfig {
+	return authConfig{
+		keys: map[string]principal{
+			hashAPIKey("reader-key"): {Name: "dashboard", Role: roleReader},
+			hashAPIKey("editor-key"): {Name: "editor", Role: roleEditor},
+			hashAPIKey("admin-key"):  {Name: "batch", Role: roleAdmin},
+		},
+		jwtSecret: testJWTSecret,
+	}
+}
+
+func signJWT(t *testing.T, method jwt.SigningMethod, key interface{}, claims tokenClaims) string {
+	t.Helper()
+	s, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestLoadKeysFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "keys.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	keys, err := loadKeysFile(write(`[{"name": "batch", "key_sha256": "` + strings.ToUpper(hashAPIKey("admin-key")) + `", "role": "admin"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := keys[hashAPIKey("admin-key")]; p.Name != "batch" || p.Role != roleAdmin {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	for _, content := range []string{
+		`[{"name": "batch", "key_sha256": "` + hashAPIKey("admin-key") + `", "role": "root"}]`,
+		`[{"name": "batch", "key_sha256": "admin-key", "role": "admin"}]`,
+		`{"name": "batch"}`,
+	} {
+		if _, err := loadKeysFile(write(content)); err == nil {
+			t.Errorf("expected an error for %s", content)
+		}
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	withAuth(t, testAuthConfig())
+	r := gin.New()
+	r.Use(errorMiddleware(), authMiddleware())
+	r.POST("/events", requireRole(roleEditor), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	r.POST("/imports", requireRole(roleAdmin), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	valid := jwt.RegisteredClaims{Subject: "ci", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+	expired := jwt.RegisteredClaims{Subject: "ci", ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour))}
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		value  string
+		status int
+	}{
+		{"no credentials", "/events", "", "", http.StatusUnauthorized},
+		{"unknown key", "/events", "X-API-Key", "wrong-key", http.StatusUnauthorized},
+		{"reader", "/events", "X-API-Key", "reader-key", http.StatusForbidden},
+		{"editor", "/events", "X-API-Key", "editor-key", http.StatusNoContent},
+		{"editor import", "/imports", "X-API-Key", "editor-key", http.StatusForbidden},
+		{"admin", "/imports", "X-API-Key", "admin-key", http.StatusNoContent},
+		{"jwt", "/imports", "Authorization", "Bearer " + signJWT(t, jwt.SigningMethodHS256, testJWTSecret, tokenClaims{"admin", valid}), http.StatusNoContent},
+		{"jwt reader", "/events", "Authorization", "Bearer " + signJWT(t, jwt.SigningMethodHS256, testJWTSecret, tokenClaims{"reader", valid}), http.StatusForbidden},
+		{"jwt expired", "/events", "Authorization", "Bearer " + signJWT(t, jwt.SigningMethodHS256, testJWTSecret, tokenClaims{"admin", expired}), http.StatusUnauthorized},
+		{"jwt wrong secret", "/events", "Authorization", "Bearer " + signJWT(t, jwt.SigningMethodHS256, []byte("other"), tokenClaims{"admin", valid}), http.StatusUnauthorized},
+		{"jwt alg none", "/events", "Authorization", "Bearer " + signJWT(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, tokenClaims{"admin", valid}), http.StatusUnauthorized},
+		{"jwt unknown role", "/events", "Authorization", "Bearer " + signJWT(t, jwt.SigningMethodHS256, testJWTSecret, tokenClaims{"root", valid}), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", tt.path, nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d %s", tt.name, tt.status, w.Code, w.Body.String())
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: WWW-Authenticate is missing", tt.name)
+		}
+	}
+}
+
+func TestWriteRoutesRequireAuth(t *testing.T) {
+	withAuth(t, testAuthConfig())
+	r := setupRouter()
+
+	for _, route := range [][2]string{
+		{"POST", "/api/v1/events"}, {"PATCH", "/api/v1/events/1"}, {"DELETE", "/api/v1/events/1"},
+		{"POST", "/api/v1/imports/infections"}, {"POST", "/api/v1/imports/medical"},
+		{"POST", "/create"}, {"PATCH", "/show/1"}, {"DELETE", "/delete/1"}, {"POST", "/import"}, {"POST", "/importmedical"},
+	} {
+		req, _ := http.NewRequest(route[0], route[1], nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: expected 401, got %d", route[0], route[1], w.Code)
+		}
+
+		req, _ = http.NewRequest(route[0], route[1], nil)
+		req.Header.Set("X-API-Key", "reader-key")
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s with reader key: expected 403, got %d", route[0], route[1], w.Code)
+		}
+	}
+}
+
+func TestRequireRead(t *testing.T) {
+	config := testAuthConfig()
+	config.requireRead = true
+	withAuth(t, config)
+	r := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/api/v1/prefectures", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/prefectures", nil)
+	req.Header.Set("X-API-Key", "reader-key")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", w.Code)
+	}
+	// 認証が必要なレスポンスは共有キャッシュに保存させない
+	if cc := w.Header().Get("Cache-Control"); !strings.HasPrefix(cc, "private") {
+		t.Errorf("Cache-Control = %q", cc)
+	}
+
+	for _, path := range []string{"/healthz", "/openapi.json"} {
+		req, _ = http.NewRequest("GET", path, nil)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"image/color"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+// 1日分の値 (from からの経過日数を X 座標に使う)
+type seriesPoint struct {
+	Date  time.Time
+	Value float64
+}
+
+type chartEvent struct {
+	Id    int
+	Title string
+	Begin time.Time
+	End   time.Time
+}
+
+type chartOptions struct {
+	Title  string
+	Metric string // daily / cumulative
+	Kind   string // line / bar
+	From   time.Time
+	To     time.Time
+	Ma     int // 移動平均の日数 (0 なら描画しない)
+	Format string
+}
+
+var chartContentTypes = map[string]string{
+	"png": "image/png",
+	"svg": "image/svg+xml",
+}
+
+// -------------
+// グラフ画像
+// -------------
+
+func Chart(c *gin.Context) {
+	db, err := database()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	pref, err := parsePlace("place", c.Param("place"))
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
+	}
+
+	opts := chartOptions{Title: pref.NameEn}
+	if opts.Metric, err = parseEnum("metric", c.DefaultQuery("metric", "daily"), []string{"daily", "cumulative"}); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if opts.Kind, err = parseEnum("type", c.DefaultQuery("type", "line"), []string{"line", "bar"}); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if opts.Format, err = parseEnum("format", c.DefaultQuery("format", "png"), []string{"png", "svg"}); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if ma := c.Query("ma"); ma != "" {
+		opts.Ma, err = strconv.Atoi(ma)
+		if err != nil || opts.Ma < 0 {
+			abortWithError(c, &paramError{"ma", ma, "must be a non-negative integer"})
+			return
+		}
+	}
+
+	// to を省略した場合は最新日、from を省略した場合は to の90日前
+	if to := c.Query("to"); to != "" {
+		if opts.To, err = parseDate("to", to); err != nil {
+			abortWithError(c, err)
+			return
+		}
+	} else {
+		var latest sql.NullTime
+		err = db.QueryRowContext(c.Request.Context(), "select max(date) from infection where name_jp = ?", pref.NameJp).Scan(&latest)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if !latest.Valid {
+			abortWithError(c, errNotFound("infection data", "感染者数のデータ")) // 404
+			return
+		}
+		opts.To = latest.Time
+	}
+	from := c.DefaultQuery("from", opts.To.AddDate(0, 0, -90).Format("2006-01-02"))
+	if opts.From, opts.To, err = parseDateRange("from", from, "to", opts.To.Format("2006-01-02"), maxPlaceRangeDays); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	series, err := querySeries(c.Request.Context(), db, pref.NameJp, opts.From, opts.To, opts.Metric)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	var events []chartEvent
+	if c.DefaultQuery("events", "true") == "true" {
+		events, err = queryChartEvents(c.Request.Context(), db, pref, opts.From, opts.To)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+	}
+
+	wt, err := renderChart(series, events, opts)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", chartContentTypes[opts.Format])
+	c.Status(http.StatusOK)
+	wt.WriteTo(c.Writer)
+}
+
+// 期間内の感染者数を取得する。daily の場合は前日との差分を取るため、from の前日から取得する
+func querySeries(ctx context.Context, db *sql.DB, place string, from, to time.Time, metric string) ([]seriesPoint, error) {
+	begin := from
+	if metric == "daily" {
+		begin = from.AddDate(0, 0, -1)
+	}
+
+	rows, err := db.QueryContext(ctx, "select date, npatients from infection where name_jp = ? and date between ? and ? order by date ASC", place, begin, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cumulative []seriesPoint
+	for rows.Next() {
+		var p seriesPoint
+		if err := rows.Scan(&p.Date, &p.Value); err != nil {
+			return nil, err
+		}
+		cumulative = append(cumulative, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if metric == "cumulative" {
+		return cumulative, nil
+	}
+	return dailySeries(cumulative, from), nil
+}
+
+// 累積値の系列を前日比 (新規感染者数) の系列に変換する
+func dailySeries(cumulative []seriesPoint, from time.Time) []seriesPoint {
+	var daily []seriesPoint
+	for i := 1; i < len(cumulative); i++ {
+		prev, cur := cumulative[i-1], cumulative[i]
+		if cur.Date.Before(from) || cur.Date.Sub(prev.Date) != 24*time.Hour {
+			continue
+		}
+		daily = append(daily, seriesPoint{Date: cur.Date, Value: cur.Value - prev.Value})
+	}
+	return daily
+}
+
+// 都道府県 (または全国) が対象で、期間と重なるメモを取得する
+func queryChartEvents(ctx context.Context, db *sql.DB, pref prefecture, from, to time.Time) ([]chartEvent, error) {
+	rows, err := db.QueryContext(ctx, "select id, title, begin, end from events where begin <= ? and end >= ? and "+eventInPrefecture+" order by begin ASC", to, from, pref.Code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []chartEvent
+	for rows.Next() {
+		var e chartEvent
+		if err := rows.Scan(&e.Id, &e.Title, &e.Begin, &e.End); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// 後方移動平均 (window 日分がそろった日から出力する)
+func movingAverage(series []seriesPoint, window int) []seriesPoint {
+	var result []seriesPoint
+	sum := 0.0
+	for i, p := range series {
+		sum += p.Value
+		if i >= window {
+			sum -= series[i-window].Value
+		}
+		if i >= window-1 {
+			result = append(result, seriesPoint{Date: p.Date, Value: sum / float64(window)})
+		}
+	}
+	return result
+}
+
+func daysFrom(from, t time.Time) float64 {
+	return t.Sub(from).Hours() / 24
+}
+
+func seriesXYs(series []seriesPoint, from time.Time) plotter.XYs {
+	xys := make(plotter.XYs, len(series))
+	for i, p := range series {
+		xys[i].X = daysFrom(from, p.Date)
+		xys[i].Y = p.Value
+	}
+	return xys
+}
+
+// X 軸 (from からの経過日数) の目盛りを日付で表示する
+func dateTicks(from time.Time) plot.Ticker {
+	return plot.TickerFunc(func(min, max float64) []plot.Tick {
+		ticks := plot.DefaultTicks{}.Ticks(min, max)
+		for i := range ticks {
+			if ticks[i].Label != "" {
+				ticks[i].Label = from.AddDate(0, 0, int(ticks[i].Value)).Format("2006-01-02")
+			}
+		}
+		return ticks
+	})
+}
+
+func renderChart(series []seriesPoint, events []chartEvent, opts chartOptions) (io.WriterTo, error) {
+	p := plot.New()
+	p.Title.Text = fmt.Sprintf("%s %s (%s - %s)", opts.Title, opts.Metric, opts.From.Format("2006-01-02"), opts.To.Format("2006-01-02"))
+	p.Y.Label.Text = "npatients"
+	p.X.Tick.Marker = dateTicks(opts.From)
+	p.X.Min = 0
+	p.X.Max = daysFrom(opts.From, opts.To)
+	p.Add(plotter.NewGrid())
+
+	switch opts.Kind {
+	case "bar":
+		// 欠けている日は 0 として棒を並べる
+		values := make(plotter.Values, int(p.X.Max)+1)
+		for _, s := range series {
+			values[int(daysFrom(opts.From, s.Date))] = s.Value
+		}
+		width := vg.Points(700 / float64(len(values)))
+		if width < 1 {
+			width = 1
+		}
+		bars, err := plotter.NewBarChart(values, width)
+		if err != nil {
+			return nil, err
+		}
+		bars.LineStyle.Width = 0
+		bars.Color = color.RGBA{R: 70, G: 130, B: 180, A: 255}
+		p.Add(bars)
+	default:
+		if len(series) > 0 {
+			line, err := plotter.NewLine(seriesXYs(series, opts.From))
+			if err != nil {
+				return nil, err
+			}
+			line.Color = color.RGBA{R: 70, G: 130, B: 180, A: 255}
+			p.Add(line)
+		}
+	}
+
+	if opts.Ma > 1 {
+		if ma := movingAverage(series, opts.Ma); len(ma) > 0 {
+			line, err := plotter.NewLine(seriesXYs(ma, opts.From))
+			if err != nil {
+				return nil, err
+			}
+			line.Color = color.RGBA{R: 220, G: 20, B: 60, A: 255}
+			line.Width = vg.Points(2)
+			p.Add(line)
+			p.Legend.Add(fmt.Sprintf("%d-day average", opts.Ma), line)
+			p.Legend.Top = true
+		}
+	}
+
+	// メモは開始日に縦線と番号を描く (フォントに日本語がないためタイトルは描かない)
+	if len(events) > 0 {
+		ymax := 0.0
+		for _, s := range series {
+			if s.Value > ymax {
+				ymax = s.Value
+			}
+		}
+		labels := plotter.XYLabels{}
+		for _, e := range events {
+			x := daysFrom(opts.From, e.Begin)
+			if x < 0 {
+				x = 0
+			}
+			line, err := plotter.NewLine(plotter.XYs{{X: x, Y: 0}, {X: x, Y: ymax}})
+			if err != nil {
+				return nil, err
+			}
+			line.Color = color.Gray{Y: 128}
+			line.Dashes = []vg.Length{vg.Points(4), vg.Points(2)}
+			p.Add(line)
+			labels.XYs = append(labels.XYs, plotter.XY{X: x, Y: ymax})
+			labels.Labels = append(labels.Labels, fmt.Sprintf("#%d", e.Id))
+		}
+		l, err := plotter.NewLabels(labels)
+		if err != nil {
+			return nil, err
+		}
+		p.Add(l)
+	}
+
+	return p.WriterTo(vg.Points(800), vg.Points(400), opts.Format)
+}
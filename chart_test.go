@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func testSeries(from time.Time, values ...float64) []seriesPoint {
+	series := make([]seriesPoint, len(values))
+	for i, v := range values {
+		series[i] = seriesPoint{Date: from.AddDate(0, 0, i), Value: v}
+	}
+	return series
+}
+
+func TestDailySeries(t *testing.T) {
+	from := time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)
+	cumulative := testSeries(from.AddDate(0, 0, -1), 100, 120, 130, 160)
+
+	daily := dailySeries(cumulative, from)
+	if len(daily) != 3 {
+		t.Fatalf("Expected 3 points, got %d", len(daily))
+	}
+	for i, want := range []float64{20, 10, 30} {
+		if daily[i].Value != want {
+			t.Errorf("Expected %v at %d, got %v", want, i, daily[i].Value)
+		}
+	}
+	if !daily[0].Date.Equal(from) {
+		t.Errorf("Expected first date %v, got %v", from, daily[0].Date)
+	}
+}
+
+func TestMovingAverage(t *testing.T) {
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	ma := movingAverage(testSeries(from, 1, 2, 3, 4, 5), 3)
+	if len(ma) != 3 {
+		t.Fatalf("Expected 3 points, got %d", len(ma))
+	}
+	for i, want := range []float64{2, 3, 4} {
+		if ma[i].Value != want {
+			t.Errorf("Expected %v at %d, got %v", want, i, ma[i].Value)
+		}
+	}
+}
+
+func TestRenderChart(t *testing.T) {
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	series := testSeries(from, 10, 20, 15, 30, 25, 40, 35, 50)
+	events := []chartEvent{{Id: 1, Title: "まん延防止等重点措置", Begin: from.AddDate(0, 0, 3), End: from.AddDate(0, 0, 10)}}
+
+	for _, tt := range []struct {
+		kind, format, prefix string
+	}{
+		{"line", "png", "\x89PNG"},
+		{"bar", "png", "\x89PNG"},
+		{"line", "svg", "<?xml"},
+		{"bar", "svg", "<?xml"},
+	} {
+		opts := chartOptions{Title: "Tokyo", Metric: "daily", Kind: tt.kind, Format: tt.format, From: from, To: from.AddDate(0, 0, 7), Ma: 3}
+		wt, err := renderChart(series, events, opts)
+		if err != nil {
+			t.Fatalf("%s/%s: %v", tt.kind, tt.format, err)
+		}
+		var buf bytes.Buffer
+		if _, err := wt.WriteTo(&buf); err != nil {
+			t.Fatalf("%s/%s: %v", tt.kind, tt.format, err)
+		}
+		if !strings.HasPrefix(buf.String(), tt.prefix) {
+			t.Errorf("%s/%s: unexpected output %q", tt.kind, tt.format, buf.String()[:10])
+		}
+	}
+}
+
+func TestChartInvalidParams(t *testing.T) {
+	r := gin.New()
+	r.GET("/chart/:place", Chart)
+
+	for _, path := range []string{
+		"/chart/unknown",
+		"/chart/東京都?format=gif",
+		"/chart/東京都?metric=deaths",
+		"/chart/東京都?type=pie",
+		"/chart/東京都?ma=-1",
+		"/chart/東京都?from=2022-02-01&to=2022-01-01",
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", path, http.StatusBadRequest, w.Code)
+		}
+	}
+}
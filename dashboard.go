@@ -0,0 +1,34 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// -------------
+// ダッシュボード
+// -------------
+
+func Dashboard(c *gin.Context) {
+	html, err := dashboardFiles.ReadFile("dashboard/index.html")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", html)
+}
+
+// ダッシュボードの JS/CSS
+func dashboardAssets() http.FileSystem {
+	sub, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	return http.FS(sub)
+}
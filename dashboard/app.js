@@ -0,0 +1,159 @@
+// 都道府県コード, 都道府県名, タイルマップ上の位置 (列, 行)
+const prefectures = [
+  ["01", "北海道", 13, 0], ["02", "青森県", 13, 2], ["03", "岩手県", 13, 3],
+  ["04", "宮城県", 13, 4], ["05", "秋田県", 12, 3], ["06", "山形県", 12, 4],
+  ["07", "福島県", 13, 5], ["08", "茨城県", 12, 6], ["09", "栃木県", 12, 5],
+  ["10", "群馬県", 11, 5], ["11", "埼玉県", 11, 6], ["12", "千葉県", 12, 7],
+  ["13", "東京都", 11, 7], ["14", "神奈川県", 11, 8], ["15", "新潟県", 10, 4],
+  ["16", "富山県", 9, 4], ["17", "石川県", 8, 4], ["18", "福井県", 8, 5],
+  ["19", "山梨県", 10, 6], ["20", "長野県", 10, 5], ["21", "岐阜県", 9, 5],
+  ["22", "静岡県", 9, 6], ["23", "愛知県", 8, 6], ["24", "三重県", 7, 6],
+  ["25", "滋賀県", 7, 5], ["26", "京都府", 6, 5], ["27", "大阪府", 5, 6],
+  ["28", "兵庫県", 5, 5], ["29", "奈良県", 6, 6], ["30", "和歌山県", 6, 7],
+  ["31", "鳥取県", 4, 5], ["32", "島根県", 3, 5], ["33", "岡山県", 4, 6],
+  ["34", "広島県", 3, 6], ["35", "山口県", 2, 6], ["36", "徳島県", 4, 8],
+  ["37", "香川県", 4, 7], ["38", "愛媛県", 3, 7], ["39", "高知県", 3, 8],
+  ["40", "福岡県", 2, 7], ["41", "佐賀県", 1, 7], ["42", "長崎県", 0, 7],
+  ["43", "熊本県", 1, 8], ["44", "大分県", 2, 8], ["45", "宮崎県", 2, 9],
+  ["46", "鹿児島県", 1, 9], ["47", "沖縄県", 0, 10],
+];
+
+const levels = {
+  "Too Danger": "too-danger",
+  "Danger": "danger",
+  "Warning": "warning",
+  "Caution": "caution",
+  "attention": "attention",
+};
+
+const $ = (id) => document.getElementById(id);
+
+function formatDate(d) {
+  return d.toISOString().slice(0, 10);
+}
+
+async function getJSON(path) {
+  const res = await fetch(path);
+  if (!res.ok) {
+    throw new Error(`${path}: ${res.status}`);
+  }
+  return res.json();
+}
+
+// Link ヘッダーの rel="next" をたどってすべてのページを取得する
+async function getAllPages(path) {
+  let result = [];
+  while (path) {
+    const res = await fetch(path);
+    if (!res.ok) {
+      throw new Error(`${path}: ${res.status}`);
+    }
+    result = result.concat((await res.json()) || []);
+    const next = /<([^>]+)>;\s*rel="next"/.exec(res.headers.get("Link") || "");
+    path = next && next[1];
+  }
+  return result;
+}
+
+function drawMap() {
+  const map = $("map");
+  for (const [code, name, col, row] of prefectures) {
+    const tile = document.createElement("div");
+    tile.id = `pref-${code}`;
+    tile.textContent = name.replace(/[都府県]$/, "");
+    tile.title = name;
+    tile.style.gridColumn = col + 1;
+    tile.style.gridRow = row + 1;
+    tile.addEventListener("click", () => {
+      $("trend-place").value = code;
+      $("hospital-place").value = code;
+      showTrend();
+    });
+    map.appendChild(tile);
+  }
+}
+
+async function showRisk(date) {
+  for (const [code] of prefectures) {
+    $(`pref-${code}`).className = "";
+  }
+  const result = await getJSON(`/api/v1/risk/${date}`);
+  for (const r of result) {
+    const pref = prefectures.find((p) => p[1] === r.name_jp);
+    if (!pref) continue;
+    const tile = $(`pref-${pref[0]}`);
+    tile.className = levels[r.message] || "";
+    tile.title = `${r.name_jp}: ${r.npatients}人 (前日 ${r.npatientsprev}人) ${r.message}`;
+  }
+}
+
+async function showTotal(date) {
+  const result = await getJSON(`/api/v1/infections/${date}/total`);
+  $("total").textContent = result.npatients.toLocaleString();
+}
+
+function showTrend() {
+  const params = new URLSearchParams({
+    format: "svg",
+    ma: "7",
+    metric: $("trend-metric").value,
+  });
+  if ($("trend-from").value) params.set("from", $("trend-from").value);
+  if ($("trend-to").value) params.set("to", $("trend-to").value);
+  $("trend-chart").src = `/api/v1/prefectures/${$("trend-place").value}/chart?${params}`;
+}
+
+async function showHospitals() {
+  const list = $("hospital-list");
+  list.innerHTML = "";
+  const code = $("hospital-place").value;
+  const type = $("hospital-type").value.trim();
+  const result = await getAllPages(`/api/v1/prefectures/${code}/facilities?limit=1000`);
+  for (const m of result) {
+    if (type && m.facilityType !== type) continue;
+    const tr = document.createElement("tr");
+    for (const v of [m.facilityName, m.facilityAddr, m.facilityType]) {
+      const td = document.createElement("td");
+      td.textContent = v;
+      tr.appendChild(td);
+    }
+    list.appendChild(tr);
+  }
+}
+
+function refresh() {
+  const date = $("date").value;
+  showRisk(date).catch(console.error);
+  showTotal(date).catch(() => ($("total").textContent = "-"));
+}
+
+window.addEventListener("DOMContentLoaded", () => {
+  for (const select of document.querySelectorAll(".places")) {
+    for (const [code, name] of prefectures) {
+      const option = document.createElement("option");
+      option.value = code;
+      option.textContent = name;
+      select.appendChild(option);
+    }
+  }
+  $("trend-place").value = "13";
+  $("hospital-place").value = "13";
+
+  const yesterday = new Date();
+  yesterday.setDate(yesterday.getDate() - 1);
+  $("date").value = formatDate(yesterday);
+
+  drawMap();
+  refresh();
+  showTrend();
+
+  $("date").addEventListener("change", refresh);
+  $("trend-form").addEventListener("submit", (e) => {
+    e.preventDefault();
+    showTrend();
+  });
+  $("hospital-form").addEventListener("submit", (e) => {
+    e.preventDefault();
+    showHospitals().catch(console.error);
+  });
+});
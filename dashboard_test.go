@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDashboard(t *testing.T) {
+	r := gin.New()
+	r.GET("/", Dashboard)
+	r.StaticFS("/dashboard", dashboardAssets())
+
+	for path, contentType := range map[string]string{
+		"/":                    "text/html",
+		"/dashboard/app.js":    "javascript",
+		"/dashboard/style.css": "text/css",
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", path, http.StatusOK, w.Code)
+		}
+		if !strings.Contains(w.Header().Get("Content-Type"), contentType) {
+			t.Errorf("%s: unexpected content type %s", path, w.Header().Get("Content-Type"))
+		}
+	}
+}
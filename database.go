@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// 接続先 (docker compose の場合は root:password@(db:3306)/training?parseTime=true)
+const databaseDSN = "root:password@(localhost:3306)/local?parseTime=true"
+
+// -------------
+// DB の接続プール
+// -------------
+
+// すべてのハンドラーで共有する接続プール。起動時に開き、停止時に閉じる
+var (
+	dbMu   sync.Mutex
+	dbPool *sql.DB
+)
+
+// 共有の接続プールを返す。起動前 (テストなど) は最初に呼ばれたときに開く
+func database() (*sql.DB, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	if dbPool == nil {
+		db, err := sql.Open(dbDriverName, databaseDSN)
+		if err != nil {
+			return nil, err
+		}
+		dbPool = db
+	}
+	return dbPool, nil
+}
+
+// 起動時に接続プールを開く。DB に接続できなくても起動する (/status で確認できる)
+func openDatabase(ctx context.Context) error {
+	_, err := database()
+	return err
+}
+
+// DB に接続できずにテーブルを作成・変更できなかった場合に再試行する間隔
+const migrationRetryInterval = 10 * time.Second
+
+// 起動時にテーブルを作成・変更する (ハンドラーでは行わない)。DB に接続できない場合も起動し、
+// 成功するまでバックグラウンドで再試行する (それまで /readyz は 503 になる)
+func startMigrations(ctx context.Context) error {
+	err := migrate(ctx)
+	if err == nil {
+		return nil
+	}
+	logger.Warn("migration failed, retrying in background", "error", err)
+	go func() {
+		ticker := time.NewTicker(migrationRetryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := migrate(ctx); err != nil {
+					logger.Warn("migration failed", "error", err)
+					continue
+				}
+				logger.Info("migration completed")
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func migrate(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	db, err := database()
+	if err != nil {
+		return err
+	}
+	if err := migrateEvents(ctx, db); err != nil {
+		return err
+	}
+	return migrateAPIKeys(ctx, db)
+}
+
+// 処理中のリクエストが終わった後に接続を閉じる
+func closeDatabase(ctx context.Context) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	if dbPool == nil {
+		return nil
+	}
+	err := dbPool.Close()
+	dbPool = nil
+	return err
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDatabaseShared(t *testing.T) {
+	first, err := database()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := database()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("expected the same pool")
+	}
+
+	if err := closeDatabase(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// 閉じた後は新しい接続プールを開く
+	reopened, err := database()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened == first {
+		t.Error("expected a new pool after close")
+	}
+}
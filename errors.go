@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// エラーコード (クライアントはこの値で分岐する。変更しないこと)
+const (
+	codeInvalidParameter = "invalid_parameter"
+	codeInvalidBody      = "invalid_body"
+	codeValidationFailed = "validation_failed"
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeRateLimited      = "rate_limited"
+	codeNotFound         = "not_found"
+	codeRouteNotFound    = "route_not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeUpstreamError    = "upstream_error"
+	codeTimeout          = "timeout"
+	codeUnavailable      = "service_unavailable"
+	codeRequestCanceled  = "request_canceled"
+	codeInternalError    = "internal_error"
+)
+
+const requestIDKey = "request_id"
+
+// クライアントが切断したリクエストのログ上のステータス (nginx に合わせる)
+const statusClientClosedRequest = 499
+
+// API のエラーレスポンス
+type apiError struct {
+	Status    int    `json:"-"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`    // 英語
+	MessageJa string `json:"message_ja"` // 日本語
+	Param     string `json:"param,omitempty"`
+	RequestId string `json:"request_id"`
+	cause     error
+}
+
+func (e *apiError) Error() string {
+	if e.cause != nil {
+		return e.Code + ": " + e.cause.Error()
+	}
+	return e.Code + ": " + e.Message
+}
+
+func (e *apiError) Unwrap() error {
+	return e.cause
+}
+
+func errInvalidBody(err error) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: codeInvalidBody, Message: "request body is not valid JSON", MessageJa: "リクエストボディが不正です", cause: err}
+}
+
+func errValidationFailed(err error) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: codeValidationFailed, Message: "validation failed: " + err.Error(), MessageJa: "入力内容に誤りがあります", cause: err}
+}
+
+// API キー・トークンがない、または無効な場合
+func errUnauthorized(err error) *apiError {
+	return &apiError{Status: http.StatusUnauthorized, Code: codeUnauthorized, Message: "a valid API key or bearer token is required", MessageJa: "有効な API キーまたはトークンが必要です", cause: err}
+}
+
+// 権限が足りない場合
+func errForbidden(required role, p principal) *apiError {
+	return &apiError{Status: http.StatusForbidden, Code: codeForbidden, Message: "this operation requires the " + required.String() + " role", MessageJa: "この操作には " + required.String() + " 権限が必要です",
+		cause: fmt.Errorf("%s has the %s role", p.Name, p.Role)}
+}
+
+// 流量制限を超えた場合 (retryAfter 秒後に再試行できる)
+func errRateLimited(retryAfter int) *apiError {
+	return &apiError{Status: http.StatusTooManyRequests, Code: codeRateLimited, Message: "too many requests, retry after " + strconv.Itoa(retryAfter) + " seconds", MessageJa: "リクエストが多すぎます。" + strconv.Itoa(retryAfter) + " 秒後に再試行してください"}
+}
+
+// resource, resourceJa は "event", "メモ" のように指定する
+func errNotFound(resource, resourceJa string) *apiError {
+	return &apiError{Status: http.StatusNotFound, Code: codeNotFound, Message: resource + " not found", MessageJa: resourceJa + "が見つかりません"}
+}
+
+// オープンデータ API の取得に失敗した場合
+func errUpstream(err error) *apiError {
+	return &apiError{Status: http.StatusBadGateway, Code: codeUpstreamError, Message: "failed to fetch open data", MessageJa: "オープンデータの取得に失敗しました", cause: err}
+}
+
+// DB に接続できない場合 (ヘルスチェック用)
+func errUnavailable(err error) *apiError {
+	return &apiError{Status: http.StatusServiceUnavailable, Code: codeUnavailable, Message: "database is unavailable", MessageJa: "データベースに接続できません", cause: err}
+}
+
+// error を apiError に変換する。DB エラーなど想定外のものは内容を返さず 500 にする
+func toAPIError(err error) *apiError {
+	// 期限切れはオープンデータの取得 (errUpstream) の場合も 504 にする
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return &apiError{Status: http.StatusGatewayTimeout, Code: codeTimeout, Message: "request timed out", MessageJa: "処理がタイムアウトしました", cause: err}
+	case errors.Is(err, context.Canceled):
+		// クライアントが切断した (レスポンスは届かない)
+		return &apiError{Status: statusClientClosedRequest, Code: codeRequestCanceled, Message: "request canceled", MessageJa: "リクエストが中断されました", cause: err}
+	}
+
+	switch e := err.(type) {
+	case *apiError:
+		return e
+	case *paramError:
+		return &apiError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Message: e.Error(), MessageJa: e.Param + " の値が不正です", Param: e.Param, cause: e}
+	default:
+		return &apiError{Status: http.StatusInternalServerError, Code: codeInternalError, Message: "internal server error", MessageJa: "サーバー内部でエラーが発生しました", cause: err}
+	}
+}
+
+// エラーをレスポンスに書き込み、以降のハンドラを中断する
+func abortWithError(c *gin.Context, err error) {
+	// /api/v1 で詰め替えたパラメータは v1 の名前で返す
+	if pe, ok := err.(*paramError); ok {
+		if aliases, ok := c.Value(paramAliasesKey).(map[string]string); ok && aliases[pe.Param] != "" {
+			renamed := *pe
+			renamed.Param = aliases[pe.Param]
+			err = &renamed
+		}
+	}
+	// 期限切れ・切断の後の DB エラーは "invalid connection" などになることがある
+	if ctxErr := c.Request.Context().Err(); ctxErr != nil && toAPIError(err).Status == http.StatusInternalServerError {
+		err = fmt.Errorf("%w (%v)", ctxErr, err)
+	}
+	// CSV・XLSX の出力を始めた後は、途中まで書いたファイルに JSON のエラーを付け足さずに中断する
+	if c.Writer.Written() {
+		requestLogger(c).Error("response aborted after streaming started", "error", err.Error())
+		c.Error(err)
+		c.Abort()
+		return
+	}
+	e := *toAPIError(err)
+	e.RequestId = c.GetString(requestIDKey)
+	// エラーレスポンスはキャッシュさせない
+	if h := c.Writer.Header(); h.Get("ETag") != "" {
+		h.Del("ETag")
+		h.Del("Last-Modified")
+		h.Set("Cache-Control", "no-store")
+	}
+	c.Error(err)
+	c.AbortWithStatusJSON(e.Status, gin.H{"error": e})
+}
+
+// リクエストIDの付与、panic の回復、未出力のエラーの書き込みを行う
+func errorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-ID")
+		if id == "" {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header("X-Request-ID", id)
+
+		defer func() {
+			if r := recover(); r != nil {
+				abortWithError(c, fmt.Errorf("panic: %v", r))
+			}
+		}()
+
+		c.Next()
+
+		if len(c.Errors) > 0 && !c.Writer.Written() {
+			abortWithError(c, c.Errors.Last().Err)
+		}
+	}
+}
+
+func NoRoute(c *gin.Context) {
+	abortWithError(c, &apiError{Status: http.StatusNotFound, Code: codeRouteNotFound, Message: "route not found", MessageJa: "URLが見つかりません"})
+}
+
+func NoMethod(c *gin.Context) {
+	abortWithError(c, &apiError{Status: http.StatusMethodNotAllowed, Code: codeMethodNotAllowed, Message: "method not allowed", MessageJa: "このメソッドは使用できません"})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
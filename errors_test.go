@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newErrorTestRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(errorMiddleware())
+	r.HandleMethodNotAllowed = true
+	r.NoRoute(NoRoute)
+	r.NoMethod(NoMethod)
+	r.GET("/param", func(c *gin.Context) {
+		_, err := parseDate("date", "2022-13-01")
+		abortWithError(c, err)
+	})
+	r.GET("/db", func(c *gin.Context) {
+		abortWithError(c, errors.New("dial tcp 127.0.0.1:3306: connect: connection refused"))
+	})
+	r.GET("/later", func(c *gin.Context) {
+		c.Error(errNotFound("event", "メモ"))
+	})
+	r.GET("/panic", func(c *gin.Context) {
+		var m map[string]int
+		m["x"] = 1
+	})
+	return r
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	r := newErrorTestRouter()
+
+	tests := []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{"GET", "/param", http.StatusBadRequest, codeInvalidParameter},
+		{"GET", "/db", http.StatusInternalServerError, codeInternalError},
+		{"GET", "/later", http.StatusNotFound, codeNotFound},
+		{"GET", "/panic", http.StatusInternalServerError, codeInternalError},
+		{"GET", "/nothing", http.StatusNotFound, codeRouteNotFound},
+		{"POST", "/param", http.StatusMethodNotAllowed, codeMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.status, w.Code)
+		}
+		var body struct {
+			Error apiError `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s %s: %v", tt.method, tt.path, err)
+		}
+		if body.Error.Code != tt.code {
+			t.Errorf("%s %s: expected code %s, got %s", tt.method, tt.path, tt.code, body.Error.Code)
+		}
+		if body.Error.Message == "" || body.Error.MessageJa == "" {
+			t.Errorf("%s %s: expected messages, got %+v", tt.method, tt.path, body.Error)
+		}
+		if body.Error.RequestId == "" || body.Error.RequestId != w.Header().Get("X-Request-ID") {
+			t.Errorf("%s %s: request id mismatch %q / %q", tt.method, tt.path, body.Error.RequestId, w.Header().Get("X-Request-ID"))
+		}
+	}
+}
+
+func TestErrorEnvelopeHidesInternalDetails(t *testing.T) {
+	r := newErrorTestRouter()
+
+	req, _ := http.NewRequest("GET", "/db", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if strings.Contains(w.Body.String(), "3306") {
+		t.Errorf("driver error leaked into response: %s", w.Body.String())
+	}
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	r := newErrorTestRouter()
+
+	req, _ := http.NewRequest("GET", "/param", nil)
+	req.Header.Set("X-Request-ID", "abc123")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Header().Get("X-Request-ID") != "abc123" {
+		t.Errorf("Expected X-Request-ID abc123, got %s", w.Header().Get("X-Request-ID"))
+	}
+}
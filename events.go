@@ -0,0 +1,645 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// -------------
+// コロナに関するメモ (events)
+// -------------
+
+// メモ (レスポンス)。begin, end は yyyy-mm-dd
+type Event struct {
+	Id          int64     `json:"id" csv:"ID"`
+	Title       string    `json:"title" csv:"タイトル"`
+	Description string    `json:"description" csv:"説明"`
+	Begin       string    `json:"begin" csv:"開始日"`
+	End         string    `json:"end" csv:"終了日"`
+	Prefectures []string  `json:"prefectures" csv:"都道府県"` // 都道府県コード (空なら全国)
+	Tags        []string  `json:"tags" csv:"タグ"`
+	CreatedAt   time.Time `json:"created_at" csv:"作成日時"`
+	UpdatedAt   time.Time `json:"updated_at" csv:"更新日時"`
+}
+
+// メモの追加 (リクエストボディ)。変更後の値の検証にも使う
+type Event_JSON struct {
+	Title       string   `json:"title" validate:"required" csv:"タイトル"`
+	Description string   `json:"description" csv:"説明"`
+	Begin       string   `json:"begin" validate:"required" csv:"開始日"`
+	End         string   `json:"end" validate:"required" csv:"終了日"`
+	Prefectures []string `json:"prefectures" csv:"都道府県"` // 都道府県 (コード・名前・ローマ字) または地方 (kanto など)。省略時は全国
+	Tags        []string `json:"tags" csv:"タグ"`
+}
+
+// メモの変更 (JSON Merge Patch。OpenAPI の説明用)
+type eventPatch struct {
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"` // null で空にする
+	Begin       *string   `json:"begin"`
+	End         *string   `json:"end"`
+	Prefectures *[]string `json:"prefectures"` // null で全国にする
+	Tags        *[]string `json:"tags"`        // null ですべて外す
+}
+
+// 都道府県とタグはカンマ区切りで取得する
+const eventColumns = "id, title, description, begin, `end`, created_at, updated_at, " +
+	"(select group_concat(pref_code order by pref_code) from event_prefectures p where p.event_id = events.id), " +
+	"(select group_concat(tag order by tag) from event_tags t where t.event_id = events.id)"
+
+// 対象の都道府県が指定した都道府県を含むか、全国 (指定なし) のメモ
+const eventInPrefecture = "(not exists (select * from event_prefectures p where p.event_id = events.id)" +
+	" or exists (select * from event_prefectures p where p.event_id = events.id and p.pref_code = ?))"
+
+// q に指定できる語の数
+const maxSearchTerms = 10
+
+// メモ1件のタグの数と長さ
+const (
+	maxEventTags = 10
+	maxTagLength = 32
+)
+
+// DB の1行 (begin, end は DATE 型)
+type eventRow struct {
+	Event
+	begin, end        time.Time
+	prefectures, tags sql.NullString
+}
+
+func (r *eventRow) dest() []interface{} {
+	return []interface{}{&r.Id, &r.Title, &r.Description, &r.begin, &r.end, &r.CreatedAt, &r.UpdatedAt, &r.prefectures, &r.tags}
+}
+
+func (r *eventRow) event() Event {
+	e := r.Event
+	e.Begin = r.begin.Format("2006-01-02")
+	e.End = r.end.Format("2006-01-02")
+	e.Prefectures = splitList(r.prefectures.String)
+	e.Tags = splitList(r.tags.String)
+	// JSON では null ではなく [] にする
+	if e.Prefectures == nil {
+		e.Prefectures = []string{}
+	}
+	if e.Tags == nil {
+		e.Tags = []string{}
+	}
+	return e
+}
+
+// 作成したメモの URL
+func eventLocation(id int64) string {
+	return "/api/v1/events/" + strconv.FormatInt(id, 10)
+}
+
+// title, begin, end が必須で begin <= end であること。DB に保存する日付を返す
+// 都道府県は地方を展開してコードに、タグは前後の空白を除いて重複をなくす
+func validateEvent(e *Event_JSON) (time.Time, time.Time, error) {
+	if err := Validate().Struct(e); err != nil {
+		return time.Time{}, time.Time{}, errValidationFailed(err)
+	}
+	var err error
+	if e.Prefectures, err = normalizeScope(e.Prefectures); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if e.Tags, err = normalizeTags(e.Tags); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return parseDateRange("begin", e.Begin, "end", e.End, 0)
+}
+
+// 都道府県・地方をコード順の都道府県コードにする。47都道府県すべての場合は全国 (空) にする
+func normalizeScope(values []string) ([]string, error) {
+	selected := map[string]bool{}
+	for _, v := range values {
+		if pref, ok := findPrefecture(strings.TrimSpace(v)); ok {
+			selected[pref.Code] = true
+			continue
+		}
+		r, ok := findRegion(strings.TrimSpace(v))
+		if !ok {
+			return nil, &paramError{"prefectures", v, "must be a prefecture name or code, or a region"}
+		}
+		for _, code := range r.Prefectures {
+			selected[code] = true
+		}
+	}
+	if len(selected) == len(prefectures) {
+		return nil, nil
+	}
+	var codes []string
+	for _, p := range prefectures {
+		if selected[p.Code] {
+			codes = append(codes, p.Code)
+		}
+	}
+	return codes, nil
+}
+
+// カンマは区切り文字のため使えない
+func normalizeTags(values []string) ([]string, error) {
+	var tags []string
+	for _, v := range values {
+		tag := strings.TrimSpace(v)
+		switch {
+		case tag == "":
+			return nil, &paramError{"tags", v, "must not be empty"}
+		case utf8.RuneCountInString(tag) > maxTagLength:
+			return nil, &paramError{"tags", v, fmt.Sprintf("must be %d characters or less", maxTagLength)}
+		case strings.Contains(tag, ","):
+			return nil, &paramError{"tags", v, "must not contain commas"}
+		}
+		if !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > maxEventTags {
+		return nil, &paramError{"tags", strings.Join(tags, ","), fmt.Sprintf("must contain %d tags or less", maxEventTags)}
+	}
+	return tags, nil
+}
+
+// 対象の都道府県とタグを置き換える
+func saveEventScope(ctx context.Context, tx *sql.Tx, id int64, e Event_JSON) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM event_prefectures WHERE event_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM event_tags WHERE event_id = ?", id); err != nil {
+		return err
+	}
+	for _, code := range e.Prefectures {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO event_prefectures (event_id, pref_code) VALUES (?, ?)", id, code); err != nil {
+			return err
+		}
+	}
+	for _, tag := range e.Tags {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO event_tags (event_id, tag) VALUES (?, ?)", id, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queryEvent(ctx context.Context, q interface {
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}, id int64, lock bool) (Event, error) {
+	query := "SELECT " + eventColumns + " FROM events WHERE id = ?"
+	if lock {
+		query += " FOR UPDATE"
+	}
+	var row eventRow
+	if err := q.QueryRowContext(ctx, query, id).Scan(row.dest()...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Event{}, errNotFound("event", "メモ") // 404
+		}
+		return Event{}, err
+	}
+	return row.event(), nil
+}
+
+// 作成・更新日時の列がない (以前の) テーブルに列を追加し、都道府県・タグのテーブルを作る (起動時に1度だけ)
+func migrateEvents(ctx context.Context, db *sql.DB) error {
+	var n int
+	err := db.QueryRowContext(ctx, "select count(*) from information_schema.columns where table_schema = database() and table_name = 'events' and column_name = 'created_at'").Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err := db.ExecContext(ctx, "alter table events add column created_at datetime not null default current_timestamp, add column updated_at datetime not null default current_timestamp")
+		if err != nil {
+			return err
+		}
+	}
+	for _, ddl := range []string{
+		"create table if not exists event_prefectures (event_id bigint not null, pref_code char(2) not null, primary key (event_id, pref_code), key (pref_code))",
+		"create table if not exists event_tags (event_id bigint not null, tag varchar(32) not null, primary key (event_id, tag), key (tag))",
+	} {
+		if _, err := db.ExecContext(ctx, ddl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// メモを追加して 201 と Location を返す
+func Create(c *gin.Context) {
+	db, err := database()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	var json Event_JSON
+	if err := c.ShouldBindJSON(&json); err != nil {
+		abortWithError(c, errInvalidBody(err)) // 400
+		return
+	}
+	begin, end, err := validateEvent(&json)
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
+	}
+
+	tx, err := db.BeginTx(c.Request.Context(), nil)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(c.Request.Context(), "INSERT INTO events (title, description, begin, `end`, created_at, updated_at) VALUES (?, ?, ?, ?, now(), now())",
+		json.Title, json.Description, begin.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if err := saveEventScope(c.Request.Context(), tx, id, json); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	event, err := queryEvent(c.Request.Context(), tx, id, false)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	markModified(c.Request.Context(), db, sourceEvents)
+	c.Header("Location", eventLocation(id))
+	c.JSON(http.StatusCreated, event) // 201
+}
+
+func Show(c *gin.Context) {
+	db, err := database()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	id, err := parseID("id", c.Param("id"))
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
+	}
+
+	event, err := queryEvent(c.Request.Context(), db, int64(id), false)
+	if err != nil {
+		abortWithError(c, err) // 404
+		return
+	}
+
+	c.JSON(http.StatusOK, event)
+}
+
+func ShowAll(c *gin.Context) {
+	db, err := database()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	format, err := exportFormat(c)
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
+	}
+
+	pg, err := newPager(c, eventSorts, Event{}, format == "json")
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
+	}
+	where, whereArgs, err := eventConditions(c)
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
+	}
+
+	clause, args := pg.clause(where, whereArgs...)
+	rows, err := db.QueryContext(c.Request.Context(), "SELECT "+eventColumns+pg.keys()+" FROM events"+clause, args...)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	defer rows.Close()
+
+	ex := newExporter(c, format, "events", Event{})
+
+	var result []Event
+	for rows.Next() {
+		var row eventRow
+		ok, err := pg.Scan(rows, row.dest()...)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if !ok {
+			break
+		}
+		if ex != nil {
+			ex.Write(row.event())
+			continue
+		}
+		result = append(result, row.event())
+	}
+	if err := rows.Err(); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if ex != nil {
+		if err := ex.Close(); err != nil {
+			c.Error(err)
+		}
+		return
+	}
+
+	pg.JSON(c, result) // 200
+}
+
+// 一覧の絞り込み
+//   - q: 空白区切りの語をすべてタイトルか説明に含む
+//   - from, to: 期間が重なる (片方のみの指定も可)
+//   - active_on: その日に期間中
+//   - prefecture: その都道府県または全国が対象 (/prefectures/:code/events ではパスの都道府県)
+//   - tag: タグが付いている (複数指定した場合はすべて)
+func eventConditions(c *gin.Context) (string, []interface{}, error) {
+	var conds []string
+	var args []interface{}
+
+	terms := strings.Fields(c.Query("q"))
+	if len(terms) > maxSearchTerms {
+		return "", nil, &paramError{"q", c.Query("q"), fmt.Sprintf("must contain %d words or less", maxSearchTerms)}
+	}
+	for _, term := range terms {
+		pattern := "%" + escapeLike(term) + "%"
+		conds = append(conds, "(title like ? or description like ?)")
+		args = append(args, pattern, pattern)
+	}
+
+	from, to := c.Query("from"), c.Query("to")
+	switch {
+	case from != "" && to != "":
+		f, t, err := parseDateRange("from", from, "to", to, 0)
+		if err != nil {
+			return "", nil, err
+		}
+		conds = append(conds, "begin <= ? and `end` >= ?")
+		args = append(args, t.Format("2006-01-02"), f.Format("2006-01-02"))
+	case from != "":
+		f, err := parseDate("from", from)
+		if err != nil {
+			return "", nil, err
+		}
+		conds = append(conds, "`end` >= ?")
+		args = append(args, f.Format("2006-01-02"))
+	case to != "":
+		t, err := parseDate("to", to)
+		if err != nil {
+			return "", nil, err
+		}
+		conds = append(conds, "begin <= ?")
+		args = append(args, t.Format("2006-01-02"))
+	}
+
+	if on := c.Query("active_on"); on != "" {
+		d, err := parseDate("active_on", on)
+		if err != nil {
+			return "", nil, err
+		}
+		conds = append(conds, "begin <= ? and `end` >= ?")
+		args = append(args, d.Format("2006-01-02"), d.Format("2006-01-02"))
+	}
+
+	place, ok := c.Params.Get("prefecture")
+	if !ok {
+		place = c.Query("prefecture")
+	}
+	if place != "" {
+		pref, err := parsePlace("prefecture", place)
+		if err != nil {
+			return "", nil, err
+		}
+		conds = append(conds, eventInPrefecture)
+		args = append(args, pref.Code)
+	}
+
+	tags := c.QueryArray("tag")
+	if len(tags) > maxEventTags {
+		return "", nil, &paramError{"tag", strings.Join(tags, ","), fmt.Sprintf("must be specified %d times or less", maxEventTags)}
+	}
+	for _, tag := range tags {
+		conds = append(conds, "exists (select * from event_tags t where t.event_id = events.id and t.tag = ?)")
+		args = append(args, strings.TrimSpace(tag))
+	}
+
+	return strings.Join(conds, " and "), args, nil
+}
+
+// like の % _ \ をそのままの文字として扱う
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// 時系列のレスポンスに期間と重なるメモを含めるか (?include=events)
+func includeEvents(c *gin.Context) (bool, error) {
+	include := c.Query("include")
+	if include == "" {
+		return false, nil
+	}
+	if _, err := parseEnum("include", include, []string{"events"}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 都道府県 (または全国) が対象で、期間と重なるメモを開始日順に取得する
+func queryPrefectureEvents(ctx context.Context, db *sql.DB, pref prefecture, from, to time.Time) ([]Event, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+eventColumns+" FROM events WHERE begin <= ? and `end` >= ? and "+eventInPrefecture+" ORDER BY begin, id",
+		to.Format("2006-01-02"), from.Format("2006-01-02"), pref.Code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var row eventRow
+		if err := rows.Scan(row.dest()...); err != nil {
+			return nil, err
+		}
+		events = append(events, row.event())
+	}
+	return events, rows.Err()
+}
+
+// JSON Merge Patch (RFC 7396) で指定した項目だけを変更し、変更後のメモを返す
+func Update(c *gin.Context) {
+	db, err := database()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	id, err := parseID("id", c.Param("id"))
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		abortWithError(c, errInvalidBody(err)) // 400
+		return
+	}
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(body, &patch); err != nil || patch == nil {
+		abortWithError(c, errInvalidBody(err)) // 400 (オブジェクト以外)
+		return
+	}
+
+	tx, err := db.BeginTx(c.Request.Context(), nil)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	current, err := queryEvent(c.Request.Context(), tx, int64(id), true)
+	if err != nil {
+		abortWithError(c, err) // 404
+		return
+	}
+
+	updated, err := applyEventPatch(Event_JSON{Title: current.Title, Description: current.Description, Begin: current.Begin, End: current.End, Prefectures: current.Prefectures, Tags: current.Tags}, patch)
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
+	}
+	begin, end, err := validateEvent(&updated)
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
+	}
+
+	_, err = tx.ExecContext(c.Request.Context(), "UPDATE events SET title = ?, description = ?, begin = ?, `end` = ?, updated_at = now() WHERE id = ?",
+		updated.Title, updated.Description, begin.Format("2006-01-02"), end.Format("2006-01-02"), id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if err := saveEventScope(c.Request.Context(), tx, int64(id), updated); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	event, err := queryEvent(c.Request.Context(), tx, int64(id), false)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	markModified(c.Request.Context(), db, sourceEvents)
+	c.JSON(http.StatusOK, event) // 200
+}
+
+// patch にある項目を上書きする。null は項目の削除 (description は空、prefectures は全国、必須の項目はエラー)
+// prefectures, tags は配列ごと置き換える
+func applyEventPatch(e Event_JSON, patch map[string]json.RawMessage) (Event_JSON, error) {
+	fields := map[string]*string{"title": &e.Title, "description": &e.Description, "begin": &e.Begin, "end": &e.End}
+	lists := map[string]*[]string{"prefectures": &e.Prefectures, "tags": &e.Tags}
+	for name, raw := range patch {
+		if list, ok := lists[name]; ok {
+			var values []string
+			if err := json.Unmarshal(raw, &values); err != nil {
+				return e, &paramError{name, string(raw), "must be an array of strings or null"}
+			}
+			*list = values
+			continue
+		}
+		field, ok := fields[name]
+		if !ok {
+			return e, &paramError{name, string(raw), "cannot be changed"}
+		}
+		var value *string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return e, &paramError{name, string(raw), "must be a string or null"}
+		}
+		if value == nil {
+			*field = ""
+			continue
+		}
+		*field = *value
+	}
+	return e, nil
+}
+
+// メモを削除して 204 を返す。存在しない場合は 404
+func Delete(c *gin.Context) {
+	db, err := database()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	id, err := parseID("id", c.Param("id"))
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
+	}
+
+	tx, err := db.BeginTx(c.Request.Context(), nil)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(c.Request.Context(), "DELETE FROM events WHERE id = ?", id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if n == 0 {
+		abortWithError(c, errNotFound("event", "メモ")) // 404
+		return
+	}
+	if err := saveEventScope(c.Request.Context(), tx, int64(id), Event_JSON{}); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	markModified(c.Request.Context(), db, sourceEvents)
+	c.Status(http.StatusNoContent) // 204
+}
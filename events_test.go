@@ -0,0 +1,309 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestApplyEventPatch(t *testing.T) {
+	current := Event_JSON{Title: "緊急事態宣言", Description: "1回目", Begin: "2020-04-07", End: "2020-05-25"}
+
+	tests := []struct {
+		patch string
+		want  Event_JSON
+	}{
+		{`{}`, current},
+		{`{"end": "2020-05-31"}`, Event_JSON{Title: "緊急事態宣言", Description: "1回目", Begin: "2020-04-07", End: "2020-05-31"}},
+		{`{"title": "宣言", "description": null}`, Event_JSON{Title: "宣言", Begin: "2020-04-07", End: "2020-05-25"}},
+		{`{"prefectures": ["27", "28"], "tags": ["緊急事態宣言"]}`, Event_JSON{Title: "緊急事態宣言", Description: "1回目", Begin: "2020-04-07", End: "2020-05-25", Prefectures: []string{"27", "28"}, Tags: []string{"緊急事態宣言"}}},
+	}
+	for _, tt := range tests {
+		var patch map[string]json.RawMessage
+		json.Unmarshal([]byte(tt.patch), &patch)
+		got, err := applyEventPatch(current, patch)
+		if err != nil {
+			t.Errorf("%s: %v", tt.patch, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v", tt.patch, got)
+		}
+	}
+
+	scoped := current
+	scoped.Prefectures, scoped.Tags = []string{"27"}, []string{"a"}
+	var clear map[string]json.RawMessage
+	json.Unmarshal([]byte(`{"prefectures": null, "tags": null}`), &clear)
+	if got, err := applyEventPatch(scoped, clear); err != nil || got.Prefectures != nil || got.Tags != nil {
+		t.Errorf("null must clear prefectures and tags, got %+v %v", got, err)
+	}
+
+	for _, patch := range []string{`{"id": 2}`, `{"created_at": "2020-01-01"}`, `{"title": 1}`, `{"tags": "a"}`, `{"prefectures": [27]}`} {
+		var p map[string]json.RawMessage
+		json.Unmarshal([]byte(patch), &p)
+		if _, err := applyEventPatch(current, p); err == nil {
+			t.Errorf("%s: expected an error", patch)
+		}
+	}
+}
+
+func TestValidateEvent(t *testing.T) {
+	begin, end, err := validateEvent(&Event_JSON{Title: "a", Begin: "2020-04-07", End: "2020-04-07"})
+	if err != nil || !begin.Equal(end) {
+		t.Errorf("expected a one-day event to be valid: %v", err)
+	}
+
+	tests := []struct {
+		event Event_JSON
+		code  string
+		param string
+	}{
+		{Event_JSON{Begin: "2020-04-07", End: "2020-05-25"}, codeValidationFailed, ""},
+		{Event_JSON{Title: "a", Begin: "2020/04/07", End: "2020-05-25"}, codeInvalidParameter, "begin"},
+		{Event_JSON{Title: "a", Begin: "2020-05-25", End: "2020-04-07"}, codeInvalidParameter, "end"},
+	}
+	for _, tt := range tests {
+		_, _, err := validateEvent(&tt.event)
+		if e := toAPIError(err); err == nil || e.Code != tt.code || e.Param != tt.param {
+			t.Errorf("%+v: unexpected error %v", tt.event, err)
+		}
+	}
+}
+
+func TestEventRow(t *testing.T) {
+	created := time.Date(2022, 12, 1, 9, 30, 0, 0, time.UTC)
+	row := eventRow{Event: Event{Id: 3, Title: "a", CreatedAt: created, UpdatedAt: created}, begin: time.Date(2020, 4, 7, 0, 0, 0, 0, time.UTC), end: time.Date(2020, 5, 25, 0, 0, 0, 0, time.UTC)}
+
+	e := row.event()
+	if e.Begin != "2020-04-07" || e.End != "2020-05-25" || e.Id != 3 {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if eventLocation(e.Id) != "/api/v1/events/3" {
+		t.Errorf("unexpected location %s", eventLocation(e.Id))
+	}
+	if got := formatCell(e.CreatedAt); got != "2022-12-01 09:30:00" {
+		t.Errorf("created_at is exported as %q", got)
+	}
+	if e.Prefectures == nil || e.Tags == nil {
+		t.Errorf("prefectures and tags must be empty arrays for nationwide events, got %+v", e)
+	}
+
+	row.prefectures = sql.NullString{String: "27,28", Valid: true}
+	row.tags = sql.NullString{String: "宣言,要請", Valid: true}
+	e = row.event()
+	if !reflect.DeepEqual(e.Prefectures, []string{"27", "28"}) || !reflect.DeepEqual(e.Tags, []string{"宣言", "要請"}) {
+		t.Errorf("unexpected scope %v %v", e.Prefectures, e.Tags)
+	}
+	if got := formatCell(e.Tags); got != "宣言,要請" {
+		t.Errorf("tags are exported as %q", got)
+	}
+}
+
+func TestNormalizeScope(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   []string
+	}{
+		{nil, nil},
+		{[]string{"兵庫県", " Osaka", "27"}, []string{"27", "28"}},
+		{[]string{"shikoku", "香川県"}, []string{"36", "37", "38", "39"}},
+		{[]string{"hokkaido", "tohoku", "kanto", "chubu", "kinki", "chugoku", "shikoku", "九州・沖縄"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := normalizeScope(tt.values)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %v %v", tt.values, got, err)
+		}
+	}
+
+	_, err := normalizeScope([]string{"27", "kansai"})
+	if e := toAPIError(err); err == nil || e.Param != "prefectures" || !strings.Contains(e.Message, "kansai") {
+		t.Errorf("expected an error for an unknown region, got %v", err)
+	}
+}
+
+func TestRegionsCoverPrefectures(t *testing.T) {
+	seen := map[string]string{}
+	for _, r := range regions {
+		for _, code := range r.Prefectures {
+			if other, ok := seen[code]; ok {
+				t.Errorf("%s is in both %s and %s", code, other, r.Code)
+			}
+			seen[code] = r.Code
+		}
+	}
+	for _, p := range prefectures {
+		if _, ok := seen[p.Code]; !ok {
+			t.Errorf("%s is not in any region", p.NameJp)
+		}
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	got, err := normalizeTags([]string{" 緊急事態宣言", "要請", "緊急事態宣言 "})
+	if err != nil || !reflect.DeepEqual(got, []string{"緊急事態宣言", "要請"}) {
+		t.Errorf("got %v %v", got, err)
+	}
+
+	tooMany := make([]string, maxEventTags+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprint("tag", i)
+	}
+	for _, tags := range [][]string{{""}, {"a,b"}, {strings.Repeat("あ", maxTagLength+1)}, tooMany} {
+		_, err := normalizeTags(tags)
+		if e := toAPIError(err); err == nil || e.Param != "tags" {
+			t.Errorf("%v: expected an error, got %v", tags, err)
+		}
+	}
+}
+
+// DB に問い合わせる前の検証 (400)
+func TestEventsInvalidRequests(t *testing.T) {
+	r := gin.New()
+	r.Use(errorMiddleware())
+	r.POST("/events", Create)
+	r.PATCH("/events/:id", Update)
+	r.DELETE("/events/:id", Delete)
+
+	tests := []struct {
+		method, path, body string
+		code               string
+	}{
+		{"POST", "/events", `{"title": "a", "begin": "2020-05-25", "end": "2020-04-07"}`, codeInvalidParameter},
+		{"POST", "/events", `{"title": "a", "begin": "2020-04-07"}`, codeValidationFailed},
+		{"POST", "/events", `[]`, codeInvalidBody},
+		{"PATCH", "/events/abc", `{}`, codeInvalidParameter},
+		{"PATCH", "/events/1", `null`, codeInvalidBody},
+		{"PATCH", "/events/1", `"title"`, codeInvalidBody},
+		{"DELETE", "/events/0", ``, codeInvalidParameter},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var body struct {
+			Error apiError `json:"error"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != http.StatusBadRequest || body.Error.Code != tt.code {
+			t.Errorf("%s %s %s: got %d %s", tt.method, tt.path, tt.body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestEventsOpenAPI(t *testing.T) {
+	spec := openapiSpec()
+	paths := spec["paths"].(map[string]map[string]interface{})
+
+	create := paths["/api/v1/events"]["post"].(map[string]interface{})["responses"].(map[string]interface{})
+	if created, ok := create["201"].(map[string]interface{}); !ok || created["headers"] == nil {
+		t.Errorf("createEvent must document 201 with Location, got %v", create)
+	}
+	remove := paths["/api/v1/events/{id}"]["delete"].(map[string]interface{})["responses"].(map[string]interface{})
+	if _, ok := remove["204"]; !ok {
+		t.Errorf("deleteEvent must document 204, got %v", remove)
+	}
+	update := paths["/api/v1/events/{id}"]["patch"].(map[string]interface{})["requestBody"].(map[string]interface{})["content"].(map[string]interface{})
+	if _, ok := update["application/merge-patch+json"]; !ok {
+		t.Errorf("updateEvent must accept application/merge-patch+json, got %v", update)
+	}
+}
+
+func TestEventConditions(t *testing.T) {
+	tests := []struct {
+		query string
+		where string
+		args  []interface{}
+	}{
+		{"", "", nil},
+		{"q=緊急事態+宣言", "(title like ? or description like ?) and (title like ? or description like ?)", []interface{}{"%緊急事態%", "%緊急事態%", "%宣言%", "%宣言%"}},
+		{"q=100%25", "(title like ? or description like ?)", []interface{}{`%100\%%`, `%100\%%`}},
+		{"from=2021-01-01&to=2021-01-31", "begin <= ? and `end` >= ?", []interface{}{"2021-01-31", "2021-01-01"}},
+		{"from=2021-01-01", "`end` >= ?", []interface{}{"2021-01-01"}},
+		{"to=2021-01-31", "begin <= ?", []interface{}{"2021-01-31"}},
+		{"active_on=2021-01-08", "begin <= ? and `end` >= ?", []interface{}{"2021-01-08", "2021-01-08"}},
+		{"prefecture=osaka", eventInPrefecture, []interface{}{"27"}},
+		{"tag=宣言&tag=+要請", "exists (select * from event_tags t where t.event_id = events.id and t.tag = ?) and exists (select * from event_tags t where t.event_id = events.id and t.tag = ?)", []interface{}{"宣言", "要請"}},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest("GET", "/api/v1/events?"+tt.query, nil)
+		where, args, err := eventConditions(c)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		if where != tt.where || fmt.Sprint(args) != fmt.Sprint(tt.args) {
+			t.Errorf("%s: got %q %v", tt.query, where, args)
+		}
+	}
+
+	// /prefectures/:code/events ではパスの都道府県で絞り込む
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("GET", "/api/v1/prefectures/13/events", nil)
+	c.Params = gin.Params{{Key: "prefecture", Value: "13"}}
+	if where, args, err := eventConditions(c); err != nil || where != eventInPrefecture || fmt.Sprint(args) != "[13]" {
+		t.Errorf("prefecture path parameter: got %q %v %v", where, args, err)
+	}
+
+	for _, query := range []string{"from=2021-01-31&to=2021-01-01", "active_on=20210108", "to=x", "q=" + strings.Repeat("a+", maxSearchTerms+1), "prefecture=kanto", "tag=" + strings.Repeat("a&tag=", maxEventTags) + "a"} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest("GET", "/api/v1/events?"+query, nil)
+		if _, _, err := eventConditions(c); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+}
+
+func TestIncludeEvents(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+		err   bool
+	}{
+		{"", false, false},
+		{"include=events", true, false},
+		{"include=facilities", false, true},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest("GET", "/api/v1/prefectures/27/series?"+tt.query, nil)
+		got, err := includeEvents(c)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("%s: got %v %v", tt.query, got, err)
+		}
+	}
+}
+
+func TestPrefectureEventsOpenAPI(t *testing.T) {
+	spec := openapiSpec()
+	paths := spec["paths"].(map[string]map[string]interface{})
+
+	if _, ok := paths["/api/v1/prefectures/{code}/events"]["get"]; !ok {
+		t.Error("listPrefectureEvents is not documented")
+	}
+	for _, path := range []string{"/api/v1/prefectures/{code}/series", "/api/v1/prefectures/{code}/recent/{date}", "/secondfirst/{place}/{date}"} {
+		op := paths[path]["get"].(map[string]interface{})
+		var include bool
+		for _, p := range op["parameters"].([]interface{}) {
+			if p.(map[string]interface{})["name"] == "include" {
+				include = true
+			}
+		}
+		schema := op["responses"].(map[string]interface{})["200"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+		if _, ok := schema["oneOf"]; !include || !ok {
+			t.Errorf("%s must document include=events, got %v", path, op)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	contentTypeCSV  = "text/csv; charset=utf-8"
+	contentTypeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+var exportFormats = []string{"json", "csv", "xlsx"}
+
+// 何行ごとにクライアントへ送るか
+const exportFlushRows = 1000
+
+// -------------
+// CSV / Excel 出力
+// -------------
+
+// 一覧を CSV または XLSX で1行ずつ書き出す。書き込みエラーは Close で返す
+type exporter struct {
+	c      *gin.Context
+	format string
+	fields []int
+	csv    *csv.Writer
+	zip    *zip.Writer
+	sheet  io.Writer
+	rows   int
+	err    error
+}
+
+// ?format= または Accept ヘッダーから出力形式を決める
+func exportFormat(c *gin.Context) (string, error) {
+	if format := c.Query("format"); format != "" {
+		return parseEnum("format", format, exportFormats)
+	}
+	accept := c.GetHeader("Accept")
+	switch {
+	case strings.Contains(accept, "text/csv"):
+		return "csv", nil
+	case strings.Contains(accept, contentTypeXLSX):
+		return "xlsx", nil
+	default:
+		return "json", nil
+	}
+}
+
+// レスポンスのヘッダーと列名を書き込む。JSON の場合は nil を返す
+// name はダウンロード時のファイル名、row は1行分の構造体
+func newExporter(c *gin.Context, format, name string, row interface{}) *exporter {
+	if format == "json" {
+		return nil
+	}
+
+	e := &exporter{c: c, format: format}
+	t := reflect.TypeOf(row)
+	var header []interface{}
+	for i := 0; i < t.NumField(); i++ {
+		if label := columnLabel(t.Field(i)); label != "" {
+			e.fields = append(e.fields, i)
+			header = append(header, label)
+		}
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	switch format {
+	case "csv":
+		c.Header("Content-Type", contentTypeCSV)
+		c.Status(http.StatusOK)
+		// Excel で文字化けしないよう BOM を付ける
+		_, e.err = c.Writer.WriteString("\ufeff")
+		e.csv = csv.NewWriter(c.Writer)
+	case "xlsx":
+		c.Header("Content-Type", contentTypeXLSX)
+		c.Status(http.StatusOK)
+		e.zip = zip.NewWriter(c.Writer)
+		e.err = writeXLSXParts(e.zip)
+		if e.err == nil {
+			e.sheet, e.err = e.zip.Create("xl/worksheets/sheet1.xml")
+		}
+		if e.err == nil {
+			_, e.err = io.WriteString(e.sheet, xml.Header+`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+		}
+	}
+	e.writeRow(header)
+	// ヘッダーを送り、以降のエラーで JSON のエラーが付け足されないようにする (abortWithError)
+	if e.err == nil {
+		if e.csv != nil {
+			e.csv.Flush()
+		}
+		if e.zip != nil {
+			e.err = e.zip.Flush()
+		}
+		c.Writer.Flush()
+	}
+	return e
+}
+
+// CSV の列名 (csv タグ、なければ json タグ)
+func columnLabel(f reflect.StructField) string {
+	if label := f.Tag.Get("csv"); label != "" && f.PkgPath == "" {
+		return label
+	}
+	return jsonName(f)
+}
+
+// row は newExporter に渡したものと同じ型の構造体
+func (e *exporter) Write(row interface{}) {
+	v := reflect.ValueOf(row)
+	values := make([]interface{}, len(e.fields))
+	for i, f := range e.fields {
+		values[i] = v.Field(f).Interface()
+	}
+	e.writeRow(values)
+
+	e.rows++
+	if e.rows%exportFlushRows == 0 && e.err == nil {
+		if e.csv != nil {
+			e.csv.Flush()
+		}
+		e.c.Writer.Flush()
+	}
+}
+
+func (e *exporter) writeRow(values []interface{}) {
+	if e.err != nil {
+		return
+	}
+	switch e.format {
+	case "csv":
+		record := make([]string, len(values))
+		for i, value := range values {
+			record[i] = csvCell(value)
+		}
+		e.err = e.csv.Write(record)
+	case "xlsx":
+		e.err = writeXLSXRow(e.sheet, values)
+	}
+}
+
+func (e *exporter) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	switch e.format {
+	case "csv":
+		e.csv.Flush()
+		return e.csv.Error()
+	case "xlsx":
+		if _, err := io.WriteString(e.sheet, `</sheetData></worksheet>`); err != nil {
+			return err
+		}
+		return e.zip.Close()
+	}
+	return nil
+}
+
+func formatCell(value interface{}) string {
+	switch v := value.(type) {
+	case time.Time:
+		if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 {
+			return v.Format("2006-01-02")
+		}
+		return v.Format("2006-01-02 15:04:05") // 作成日時など
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, ",") // 都道府県・タグ
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// CSV のセルの文字列。= + - @ タブ CR で始まる数値以外の値は表計算ソフトが数式として
+// 実行しないよう先頭に ' を付ける (メモのタイトルなど利用者が入力した値を含むため)。
+// XLSX の文字列のセル (inlineStr) は数式として扱われないため付けない
+func csvCell(value interface{}) string {
+	s := formatCell(value)
+	switch value.(type) {
+	case int, int64, float64:
+		return s
+	}
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// 数値は数値のセル、それ以外は文字列のセルにする
+func writeXLSXRow(w io.Writer, values []interface{}) error {
+	var b strings.Builder
+	b.WriteString("<row>")
+	for _, value := range values {
+		switch v := value.(type) {
+		case int, int64, float64:
+			b.WriteString(`<c t="n"><v>` + formatCell(v) + `</v></c>`)
+		default:
+			b.WriteString(`<c t="inlineStr"><is><t>`)
+			xml.EscapeText(&b, []byte(formatCell(v)))
+			b.WriteString(`</t></is></c>`)
+		}
+	}
+	b.WriteString("</row>")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// シート以外の XLSX の構成ファイル
+func writeXLSXParts(z *zip.Writer) error {
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets>` +
+			`</workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`</Relationships>`},
+	}
+	for _, p := range parts {
+		w, err := z.Create(p.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, xml.Header+p.body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestExportFormat(t *testing.T) {
+	tests := []struct {
+		query, accept string
+		want          string
+		wantErr       bool
+	}{
+		{"", "", "json", false},
+		{"", "application/json", "json", false},
+		{"", "text/csv", "csv", false},
+		{"", contentTypeXLSX, "xlsx", false},
+		{"format=csv", "application/json", "csv", false},
+		{"format=xlsx", "text/csv", "xlsx", false},
+		{"format=xml", "", "", true},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("GET", "/?"+tt.query, nil)
+		c.Request.Header.Set("Accept", tt.accept)
+
+		got, err := exportFormat(c)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("exportFormat(%q, %q) = %q, %v", tt.query, tt.accept, got, err)
+		}
+	}
+}
+
+func exportRows(t *testing.T, format string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	ex := newExporter(c, format, "infections", infection{})
+	ex.Write(infection{Date: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), NameJp: "東京都", Npatients: 100})
+	ex.Write(infection{Date: time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC), NameJp: "東京都", Npatients: 120})
+	if err := ex.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestExportCSV(t *testing.T) {
+	w := exportRows(t, "csv")
+
+	if got := w.Header().Get("Content-Type"); got != contentTypeCSV {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="infections.csv"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	body := w.Body.String()
+	if !strings.HasPrefix(body, "\ufeff") {
+		t.Fatal("BOM is missing")
+	}
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(body, "\ufeff"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"日付", "都道府県", "感染者数"}, {"2022-01-01", "東京都", "100"}, {"2022-01-02", "東京都", "120"}}
+	if len(records) != len(want) {
+		t.Fatalf("records = %v", records)
+	}
+	for i := range want {
+		if strings.Join(records[i], ",") != strings.Join(want[i], ",") {
+			t.Errorf("record %d = %v, want %v", i, records[i], want[i])
+		}
+	}
+}
+
+func TestExportXLSX(t *testing.T) {
+	w := exportRows(t, "xlsx")
+
+	if got := w.Header().Get("Content-Type"); got != contentTypeXLSX {
+		t.Errorf("Content-Type = %q", got)
+	}
+	z, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sheet string
+	for _, f := range z.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			r, _ := f.Open()
+			b, _ := io.ReadAll(r)
+			sheet = string(b)
+		}
+	}
+	for _, s := range []string{"<t>都道府県</t>", `<c t="n"><v>120</v></c>`, "<t>2022-01-01</t>"} {
+		if !strings.Contains(sheet, s) {
+			t.Errorf("sheet does not contain %s", s)
+		}
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	if ex := newExporter(c, "json", "infections", infection{}); ex != nil {
+		t.Error("newExporter returned an exporter for json")
+	}
+}
+
+func TestCSVCellEscapesFormulas(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tx", "'\tx"},
+		{"\rx", "'\rx"},
+		{[]string{"=1", "2"}, "'=1,2"},
+		{"緊急事態宣言", "緊急事態宣言"},
+		{"", ""},
+		{-5, "-5"},
+		{-1.5, "-1.5"},
+	}
+	for _, tt := range tests {
+		if got := csvCell(tt.value); got != tt.want {
+			t.Errorf("csvCell(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	ex := newExporter(c, "csv", "events", Event{})
+	ex.Write(Event{Title: "=cmd|' /C calc'!A0", Begin: "2020-04-07", End: "2020-05-25"})
+	if err := ex.Close(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(w.Body.String(), "\ufeff"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records[1][1] != "'=cmd|' /C calc'!A0" {
+		t.Errorf("title is exported as %q", records[1][1])
+	}
+
+	// XLSX の文字列のセルはそのまま出力する
+	var b strings.Builder
+	if err := writeXLSXRow(&b, []interface{}{"-5日間の自粛", -5}); err != nil {
+		t.Fatal(err)
+	}
+	if want := `<row><c t="inlineStr"><is><t>-5日間の自粛</t></is></c><c t="n"><v>-5</v></c></row>`; b.String() != want {
+		t.Errorf("expected %s, got %s", want, b.String())
+	}
+}
+
+// 出力を始めた後のエラーは JSON のエラーを付け足さない
+func TestExportErrorAfterStreaming(t *testing.T) {
+	for _, format := range []string{"csv", "xlsx"} {
+		r := gin.New()
+		r.Use(errorMiddleware())
+		r.GET("/", func(c *gin.Context) {
+			ex := newExporter(c, format, "infections", infection{})
+			ex.Write(infection{Date: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), NameJp: "東京都", Npatients: 100})
+			abortWithError(c, io.ErrUnexpectedEOF)
+		})
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK || strings.Contains(w.Body.String(), `"error"`) {
+			t.Errorf("%s: got %d %q", format, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API が参照するテーブル (readyz で存在を確認する)
+var requiredTables = []string{"infection", "medical", "events", "event_prefectures", "event_tags", "api_keys"}
+
+// 最後の取り込みからこれ以上経っていたら stale とする
+const staleAfter = 48 * time.Hour
+
+// -------------
+// ヘルスチェック・データの鮮度
+// -------------
+
+// プロセスが動いているか
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// DB に接続でき、テーブルがそろっているか。そうでなければ 503
+func Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+
+	checks := gin.H{"database": "ok", "tables": "ok"}
+	status := http.StatusOK
+
+	db, err := database()
+	if err == nil {
+		err = db.PingContext(ctx)
+	}
+	if err != nil {
+		checks["database"] = err.Error()
+		checks["tables"] = "unknown"
+		status = http.StatusServiceUnavailable
+	} else if missing, err := missingTables(ctx, db); err != nil {
+		checks["tables"] = err.Error()
+		status = http.StatusServiceUnavailable
+	} else if len(missing) > 0 {
+		checks["tables"] = "missing: " + strings.Join(missing, ", ")
+		status = http.StatusServiceUnavailable
+	}
+
+	result := "ok"
+	if status != http.StatusOK {
+		result = "unavailable"
+	}
+	c.JSON(status, gin.H{"status": result, "checks": checks})
+}
+
+func missingTables(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "select table_name from information_schema.tables where table_schema = database()")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, table := range requiredTables {
+		if !contains(tables, table) {
+			missing = append(missing, table)
+		}
+	}
+	return missing, nil
+}
+
+// 取り込みの状況
+type datasetStatus struct {
+	LastImport *time.Time `json:"last_import"` // 記録がなければ null
+	Stale      bool       `json:"stale"`
+	Rows       int        `json:"rows"`
+}
+
+type serviceStatus struct {
+	LatestInfectionDate *time.Time               `json:"latest_infection_date"`
+	Datasets            map[string]datasetStatus `json:"datasets"`
+}
+
+// 最新の感染者数の日付、データごとの最終取り込み日時と件数
+func Status(c *gin.Context) {
+	db, err := database()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var status serviceStatus
+	var latest sql.NullTime
+	if err := db.QueryRowContext(ctx, "select max(date) from infection").Scan(&latest); err != nil {
+		abortWithError(c, errUnavailable(err))
+		return
+	}
+	if latest.Valid {
+		status.LatestInfectionDate = &latest.Time
+	}
+
+	// 取り込みを一度もしていない場合はテーブルがない
+	imports := map[string]time.Time{}
+	if rows, err := db.QueryContext(ctx, "select name, modified_at from last_modified"); err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			var t time.Time
+			if err := rows.Scan(&name, &t); err != nil {
+				abortWithError(c, err)
+				return
+			}
+			imports[name] = t
+		}
+		if err := rows.Err(); err != nil {
+			abortWithError(c, err)
+			return
+		}
+	}
+
+	status.Datasets = map[string]datasetStatus{}
+	for source, table := range map[string]string{sourceInfections: "infection", sourceMedical: "medical", sourceEvents: "events"} {
+		var d datasetStatus
+		if err := db.QueryRowContext(ctx, "select count(*) from "+table).Scan(&d.Rows); err != nil {
+			abortWithError(c, errUnavailable(err))
+			return
+		}
+		if t, ok := imports[source]; ok {
+			d.LastImport = &t
+		}
+		// メモは手で追加するので古くても問題ない
+		if source != sourceEvents {
+			d.Stale = d.LastImport == nil || time.Since(*d.LastImport) > staleAfter
+		}
+		status.Datasets[source] = d
+	}
+
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthz(t *testing.T) {
+	r := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != `{"status":"ok"}` {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	r := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var body struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	switch w.Code {
+	case http.StatusOK:
+		if body.Status != "ok" || body.Checks["database"] != "ok" {
+			t.Errorf("unexpected body %s", w.Body.String())
+		}
+	case http.StatusServiceUnavailable:
+		// DB がない環境
+		if body.Status != "unavailable" || body.Checks["database"] == "ok" && body.Checks["tables"] == "ok" {
+			t.Errorf("unexpected body %s", w.Body.String())
+		}
+	default:
+		t.Errorf("unexpected status %d", w.Code)
+	}
+}
+
+func TestStatusWithoutDatabase(t *testing.T) {
+	r := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/status", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code == http.StatusOK {
+		var status serviceStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := status.Datasets[sourceInfections]; !ok {
+			t.Errorf("unexpected body %s", w.Body.String())
+		}
+		return
+	}
+
+	var body struct {
+		Error apiError `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusServiceUnavailable || body.Error.Code != codeUnavailable {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+}
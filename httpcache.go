@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 更新日時を記録するデータの単位
+const (
+	sourceInfections = "infections" // Import
+	sourceMedical    = "medical"    // ImportMedical
+	sourceEvents     = "events"     // メモの追加・変更・削除
+)
+
+// 更新日時を DB から読み直す間隔 (別のサーバーで取り込んだ場合に反映されるまでの時間)
+const lastModifiedRefresh = time.Minute
+
+// -------------
+// ETag / Last-Modified による HTTP キャッシュ
+// -------------
+
+// ルートごとのキャッシュの設定
+type cachePolicy struct {
+	Sources      []string // レスポンスが依存するデータ
+	CacheControl string
+}
+
+var (
+	infectionsCache = cachePolicy{[]string{sourceInfections}, "public, max-age=300"}
+	seriesCache     = cachePolicy{[]string{sourceInfections, sourceEvents}, "public, max-age=300"} // 感染者数とメモ (?include=events、メモの前後の比較)
+	medicalCache    = cachePolicy{[]string{sourceMedical}, "public, max-age=300"}
+	areasCache      = cachePolicy{[]string{sourceInfections, sourceMedical}, "public, max-age=300"}
+	chartCache      = cachePolicy{[]string{sourceInfections, sourceEvents}, "public, max-age=60"}
+	eventsCache     = cachePolicy{[]string{sourceEvents}, "no-cache"} // 毎回 ETag で確認させる
+)
+
+var lastModifiedTimes = struct {
+	sync.Mutex
+	times    map[string]time.Time
+	loadedAt time.Time
+	loading  bool // DB から読み込み中
+}{}
+
+// GET/HEAD に ETag, Last-Modified, Cache-Control を付け、条件付きリクエストには 304 を返す
+// 更新日時が記録されていないデータに依存する場合は何もしない
+func cached(policy cachePolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			c.Next()
+			return
+		}
+		modified := lastModified(policy.Sources...)
+		if modified.IsZero() {
+			c.Next()
+			return
+		}
+
+		etag := cacheETag(c.Request, modified)
+		h := c.Writer.Header()
+		h.Set("Cache-Control", cacheControl(policy.CacheControl))
+		h.Set("ETag", etag)
+		h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		h.Add("Vary", "Accept") // CSV/XLSX の出力は Accept でも切り替わる
+
+		if notModified(c.Request, etag, modified) {
+			c.AbortWithStatus(http.StatusNotModified) // 304
+			return
+		}
+		c.Next()
+	}
+}
+
+// パス・クエリ・Accept と更新日時から ETag を作る
+func cacheETag(r *http.Request, modified time.Time) string {
+	h := sha256.New()
+	h.Write([]byte(r.URL.Path + "?" + r.URL.Query().Encode() + "\n" + r.Header.Get("Accept") + "\n" + modified.UTC().Format(time.RFC3339Nano)))
+	return `W/"` + hex.EncodeToString(h.Sum(nil))[:32] + `"`
+}
+
+// If-None-Match を優先し、なければ If-Modified-Since で判定する
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(ims)
+}
+
+// sources のうち最も新しい更新日時 (1つでも記録がなければゼロ値)
+func lastModified(sources ...string) time.Time {
+	refreshLastModified()
+
+	lastModifiedTimes.Lock()
+	defer lastModifiedTimes.Unlock()
+
+	var latest time.Time
+	for _, source := range sources {
+		t, ok := lastModifiedTimes.times[source]
+		if !ok {
+			return time.Time{}
+		}
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// 読み直す間隔を過ぎていれば DB から読み直す。読み込みはロックの外で1つのリクエストだけが行い、
+// その間の他のリクエストは前回の値を使う
+func refreshLastModified() {
+	lastModifiedTimes.Lock()
+	if lastModifiedTimes.loading || time.Since(lastModifiedTimes.loadedAt) <= lastModifiedRefresh {
+		lastModifiedTimes.Unlock()
+		return
+	}
+	lastModifiedTimes.loading = true
+	lastModifiedTimes.Unlock()
+
+	times, err := lastModifiedLoader()
+
+	lastModifiedTimes.Lock()
+	defer lastModifiedTimes.Unlock()
+	lastModifiedTimes.loading = false
+	lastModifiedTimes.loadedAt = time.Now()
+	if err != nil {
+		logger.Warn("failed to load last modified times", "error", err)
+		return
+	}
+
+	for source, t := range times {
+		prev, ok := lastModifiedTimes.times[source]
+		if ok && !t.After(prev) {
+			// 読み込み中にこのサーバーで記録した更新日時を古い値で戻さない
+			times[source] = prev
+			continue
+		}
+		// 別のサーバーで取り込んだデータの集計結果を捨てる
+		analyticsCache.Invalidate(source)
+	}
+	for source, prev := range lastModifiedTimes.times {
+		if _, ok := times[source]; !ok {
+			times[source] = prev
+		}
+	}
+	lastModifiedTimes.times = times
+}
+
+// テストでは DB の代わりに差し替える
+var lastModifiedLoader = loadLastModified
+
+func loadLastModified() (map[string]time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	db, err := database()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "select name, modified_at from last_modified")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	times := map[string]time.Time{}
+	for rows.Next() {
+		var name string
+		var t time.Time
+		if err := rows.Scan(&name, &t); err != nil {
+			return nil, err
+		}
+		times[name] = t
+	}
+	return times, rows.Err()
+}
+
+// 更新日時を記録するテーブルを作る (起動時に1度だけ)
+func migrateLastModified(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	db, err := database()
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "create table if not exists last_modified (name varchar(32) primary key, modified_at datetime not null)")
+	return err
+}
+
+// データを更新したことを記録する。失敗してもレスポンスには影響させない
+// コミット済みの更新を記録するため、クライアントが切断してもリクエストのコンテキストでは中断しない
+func markModified(ctx context.Context, db *sql.DB, source string) {
+	analyticsCache.Invalidate(source)
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	_, err := db.ExecContext(ctx, "insert into last_modified (name, modified_at) values (?, ?) on duplicate key update modified_at = values(modified_at)", source, now)
+	if err != nil {
+		logger.Warn("failed to record last modified time", "dataset", source, "error", err)
+		return
+	}
+
+	lastModifiedTimes.Lock()
+	defer lastModifiedTimes.Unlock()
+	if lastModifiedTimes.times == nil {
+		lastModifiedTimes.times = map[string]time.Time{}
+	}
+	lastModifiedTimes.times[source] = now
+}
+
+// 参照に認証が必要な場合は CDN などの共有キャッシュに保存させない
+func cacheControl(value string) string {
+	if auth.requireRead {
+		return strings.Replace(value, "public", "private", 1)
+	}
+	return value
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setLastModified(t *testing.T, times map[string]time.Time) {
+	lastModifiedTimes.Lock()
+	lastModifiedTimes.times = times
+	lastModifiedTimes.loadedAt = time.Now()
+	lastModifiedTimes.Unlock()
+
+	t.Cleanup(func() {
+		lastModifiedTimes.Lock()
+		lastModifiedTimes.times = nil
+		lastModifiedTimes.loadedAt = time.Time{}
+		lastModifiedTimes.Unlock()
+	})
+}
+
+func cacheTestRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(errorMiddleware())
+	r.GET("/infections", cached(infectionsCache), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	r.GET("/events", cached(eventsCache), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	r.GET("/error", cached(infectionsCache), func(c *gin.Context) {
+		abortWithError(c, errors.New("db error"))
+	})
+	return r
+}
+
+func serveCache(r *gin.Engine, path string, header map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCached(t *testing.T) {
+	modified := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	setLastModified(t, map[string]time.Time{sourceInfections: modified})
+	r := cacheTestRouter()
+
+	w := serveCache(r, "/infections?from=2022-01-01", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Header().Get("Cache-Control") != "public, max-age=300" || w.Header().Get("Last-Modified") != "Sun, 02 Jan 2022 03:04:05 GMT" {
+		t.Fatalf("unexpected headers %v", w.Header())
+	}
+
+	tests := []struct {
+		path   string
+		header map[string]string
+		want   int
+	}{
+		{"/infections?from=2022-01-01", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"/infections?from=2022-01-01", map[string]string{"If-None-Match": `"other", ` + etag}, http.StatusNotModified},
+		{"/infections?from=2022-01-02", map[string]string{"If-None-Match": etag}, http.StatusOK},
+		{"/infections?from=2022-01-01", map[string]string{"If-None-Match": etag, "Accept": "text/csv"}, http.StatusOK},
+		{"/infections", map[string]string{"If-Modified-Since": "Sun, 02 Jan 2022 03:04:05 GMT"}, http.StatusNotModified},
+		{"/infections", map[string]string{"If-Modified-Since": "Sun, 02 Jan 2022 03:04:04 GMT"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := serveCache(r, tt.path, tt.header); w.Code != tt.want {
+			t.Errorf("%s %v: expected status %d, got %d", tt.path, tt.header, tt.want, w.Code)
+		}
+	}
+
+	// 取り込み後は ETag が変わる
+	setLastModified(t, map[string]time.Time{sourceInfections: modified.Add(time.Hour)})
+	if w := serveCache(r, "/infections?from=2022-01-01", map[string]string{"If-None-Match": etag}); w.Code != http.StatusOK {
+		t.Errorf("Expected status %d after import, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestCachedWithoutLastModified(t *testing.T) {
+	setLastModified(t, map[string]time.Time{sourceInfections: time.Now()})
+	r := cacheTestRouter()
+
+	// メモは一度も更新されていない
+	w := serveCache(r, "/events", nil)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != "" || w.Header().Get("Cache-Control") != "" {
+		t.Errorf("unexpected response %d %v", w.Code, w.Header())
+	}
+
+	// エラーレスポンスはキャッシュさせない
+	w = serveCache(r, "/error", nil)
+	if w.Code != http.StatusInternalServerError || w.Header().Get("ETag") != "" || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("unexpected response %d %v", w.Code, w.Header())
+	}
+}
+
+// DB から読み込んでいる間も他のリクエストは前回の値ですぐに返る
+func TestLastModifiedRefreshOutsideLock(t *testing.T) {
+	old := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	setLastModified(t, map[string]time.Time{sourceInfections: old})
+	lastModifiedTimes.Lock()
+	lastModifiedTimes.loadedAt = time.Time{} // 読み直す間隔を過ぎている
+	lastModifiedTimes.Unlock()
+
+	loading, release := make(chan struct{}), make(chan struct{})
+	defer func(loader func() (map[string]time.Time, error)) { lastModifiedLoader = loader }(lastModifiedLoader)
+	lastModifiedLoader = func() (map[string]time.Time, error) {
+		close(loading)
+		<-release
+		return map[string]time.Time{sourceInfections: old, sourceMedical: old}, nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		lastModified(sourceInfections)
+		close(done)
+	}()
+	<-loading
+
+	result := make(chan time.Time)
+	go func() { result <- lastModified(sourceInfections) }()
+	select {
+	case got := <-result:
+		if !got.Equal(old) {
+			t.Errorf("expected %v, got %v", old, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lastModified blocked while loading")
+	}
+
+	// 読み込み中にこのサーバーで更新した場合は新しい方を残す
+	newer := old.Add(time.Hour)
+	lastModifiedTimes.Lock()
+	lastModifiedTimes.times[sourceInfections] = newer
+	lastModifiedTimes.Unlock()
+	close(release)
+	<-done
+
+	if got := lastModified(sourceInfections); !got.Equal(newer) {
+		t.Errorf("expected %v, got %v", newer, got)
+	}
+	if got := lastModified(sourceMedical); !got.Equal(old) {
+		t.Errorf("expected loaded time %v, got %v", old, got)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// window を指定しない場合の日数と指定できる範囲
+const (
+	defaultImpactWindow = 28
+	minImpactWindow     = 7
+	maxImpactWindow     = 90
+)
+
+// 前後それぞれで傾向を求めるのに必要な日数 (データが欠けている日を除く)
+const minImpactDays = 7
+
+// 実効再生産数を増加率から求めるときの世代時間 (日)
+const generationTime = 5.0
+
+// -------------
+// メモ (対策) の前後で感染者数の傾向を比べる
+// -------------
+
+type eventImpact struct {
+	Event       Event         `json:"event"`
+	Prefectures []string      `json:"prefectures"` // 集計した都道府県コード (全国の場合は47都道府県)
+	Window      int           `json:"window"`      // 前後それぞれの日数
+	Begin       *impactPeriod `json:"begin"`       // 開始日の前後 (データが足りない場合は null)
+	End         *impactPeriod `json:"end"`         // 終了日の翌日の前後 (データが足りない場合は null)
+}
+
+// 境界 (Date) の前後の比較
+type impactPeriod struct {
+	Date             time.Time   `json:"date"` // この日から後の期間
+	Before           trendStats  `json:"before"`
+	After            trendStats  `json:"after"`
+	GrowthRateChange float64     `json:"growth_rate_change"` // 1日あたりの増加率の変化 (後 - 前)
+	RtChange         float64     `json:"rt_change"`          // 実効再生産数の変化 (後 - 前)
+	ITS              itsEstimate `json:"its"`
+}
+
+// 期間内の新規感染者数の傾向 (log(新規感染者数 + 1) の回帰直線)
+type trendStats struct {
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Days       int       `json:"days"`        // 傾向に使った日数
+	Cases      float64   `json:"cases"`       // 新規感染者数の合計
+	MeanDaily  float64   `json:"mean_daily"`  // 1日あたりの新規感染者数
+	GrowthRate float64   `json:"growth_rate"` // 1日あたりの増加率 (0.05 なら 5%/日)
+	Rt         float64   `json:"rt"`          // 世代時間5日とした実効再生産数の目安
+}
+
+// 分割時系列回帰 (interrupted time series)
+// log(y + 1) = b0 + b1·t + b2·D + b3·t·D (t は境界からの日数、D は境界以降なら 1)
+type itsEstimate struct {
+	LevelChange    float64 `json:"level_change"`    // 境界での水準の変化 (0.2 なら 20% 増)
+	SlopeChange    float64 `json:"slope_change"`    // 1日あたりの増加率の変化
+	ExpectedCases  float64 `json:"expected_cases"`  // 前の傾向が続いた場合の後の期間の新規感染者数
+	ObservedCases  float64 `json:"observed_cases"`  // 後の期間の新規感染者数
+	RelativeEffect float64 `json:"relative_effect"` // 実際 / 予測 - 1 (-0.3 なら 30% 減)
+}
+
+// メモの対象の都道府県 (または全国) で、開始日・終了日の前後 window 日の新規感染者数を比べる
+func EventImpact(c *gin.Context) {
+	db, err := database()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	id, err := parseID("id", c.Param("id"))
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
+	}
+	window := defaultImpactWindow
+	if s := c.Query("window"); s != "" {
+		window, err = strconv.Atoi(s)
+		if err != nil || window < minImpactWindow || window > maxImpactWindow {
+			abortWithError(c, &paramError{"window", s, fmt.Sprintf("must be an integer between %d and %d", minImpactWindow, maxImpactWindow)})
+			return
+		}
+	}
+
+	event, err := queryEvent(c.Request.Context(), db, int64(id), false)
+	if err != nil {
+		abortWithError(c, err) // 404
+		return
+	}
+
+	result := eventImpact{Event: event, Prefectures: event.Prefectures, Window: window}
+	if len(result.Prefectures) == 0 {
+		for _, p := range prefectures {
+			result.Prefectures = append(result.Prefectures, p.Code)
+		}
+	}
+	var places []string
+	for _, code := range result.Prefectures {
+		pref, _ := findPrefecture(code)
+		places = append(places, pref.NameJp)
+	}
+
+	begin, _ := time.Parse("2006-01-02", event.Begin)
+	end, _ := time.Parse("2006-01-02", event.End)
+	if result.Begin, err = queryImpactPeriod(c.Request.Context(), db, places, begin, window); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if result.End, err = queryImpactPeriod(c.Request.Context(), db, places, end.AddDate(0, 0, 1), window); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// date の前後 window 日の新規感染者数を取得して比べる
+func queryImpactPeriod(ctx context.Context, db *sql.DB, places []string, date time.Time, window int) (*impactPeriod, error) {
+	from := date.AddDate(0, 0, -window)
+	cumulative, err := queryCumulative(ctx, db, places, from.AddDate(0, 0, -1), date.AddDate(0, 0, window-1))
+	if err != nil {
+		return nil, err
+	}
+	return analyzeImpact(dailySeries(combineSeries(places, cumulative), from), date), nil
+}
+
+// date より前と date 以降を比べる。どちらかが minImpactDays 日に満たない場合は nil
+func analyzeImpact(daily []seriesPoint, date time.Time) *impactPeriod {
+	var before, after []seriesPoint
+	for _, p := range daily {
+		if p.Date.Before(date) {
+			before = append(before, p)
+		} else {
+			after = append(after, p)
+		}
+	}
+	if len(before) < minImpactDays || len(after) < minImpactDays {
+		return nil
+	}
+
+	period := &impactPeriod{Date: date, Before: trendOf(before, date), After: trendOf(after, date)}
+	period.GrowthRateChange = period.After.GrowthRate - period.Before.GrowthRate
+	period.RtChange = period.After.Rt - period.Before.Rt
+	period.ITS = interruptedTimeSeries(before, after, date)
+	return period
+}
+
+func trendOf(series []seriesPoint, date time.Time) trendStats {
+	stats := trendStats{From: series[0].Date, To: series[len(series)-1].Date, Days: len(series)}
+	x := make([][]float64, len(series))
+	y := make([]float64, len(series))
+	for i, p := range series {
+		x[i] = []float64{1, daysFrom(date, p.Date)}
+		y[i] = logCases(p.Value)
+		stats.Cases += p.Value
+	}
+	stats.MeanDaily = stats.Cases / float64(len(series))
+	if b, ok := leastSquares(x, y); ok {
+		stats.GrowthRate = math.Exp(b[1]) - 1
+		stats.Rt = math.Exp(b[1] * generationTime)
+	}
+	return stats
+}
+
+func interruptedTimeSeries(before, after []seriesPoint, date time.Time) itsEstimate {
+	var x [][]float64
+	var y []float64
+	for _, p := range before {
+		x = append(x, []float64{1, daysFrom(date, p.Date), 0, 0})
+		y = append(y, logCases(p.Value))
+	}
+	for _, p := range after {
+		t := daysFrom(date, p.Date)
+		x = append(x, []float64{1, t, 1, t})
+		y = append(y, logCases(p.Value))
+	}
+	b, ok := leastSquares(x, y)
+	if !ok {
+		return itsEstimate{}
+	}
+
+	est := itsEstimate{
+		LevelChange: math.Exp(b[2]) - 1,
+		SlopeChange: math.Exp(b[1]+b[3]) - math.Exp(b[1]),
+	}
+	for _, p := range after {
+		est.ExpectedCases += math.Max(0, math.Exp(b[0]+b[1]*daysFrom(date, p.Date))-1)
+		est.ObservedCases += p.Value
+	}
+	if est.ExpectedCases > 0 {
+		est.RelativeEffect = est.ObservedCases/est.ExpectedCases - 1
+	}
+	return est
+}
+
+// 0 人の日があるため 1 を足す。訂正による負の値は 0 とする
+func logCases(v float64) float64 {
+	return math.Log(math.Max(v, 0) + 1)
+}
+
+// 最小二乗法 (正規方程式を部分ピボット選択つきのガウスの消去法で解く)。解けない場合は false
+func leastSquares(x [][]float64, y []float64) ([]float64, bool) {
+	n := len(x[0])
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = make([]float64, n+1)
+	}
+	for k, row := range x {
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				a[i][j] += row[i] * row[j]
+			}
+			a[i][n] += row[i] * y[k]
+		}
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-9 {
+			return nil, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for r := 0; r < n; r++ {
+			if r == col {
+				continue
+			}
+			f := a[r][col] / a[col][col]
+			for j := col; j <= n; j++ {
+				a[r][j] -= f * a[col][j]
+			}
+		}
+	}
+
+	b := make([]float64, n)
+	for i := range b {
+		b[i] = a[i][n] / a[i][i]
+	}
+	return b, true
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// log(y + 1) が境界の前は傾き before、境界で level だけ変わり、後は傾き after の系列
+func impactSeries(date time.Time, window int, before, level, after float64) []seriesPoint {
+	var series []seriesPoint
+	for t := -window; t < window; t++ {
+		v := 5 + before*float64(t)
+		if t >= 0 {
+			v = 5 + level + after*float64(t)
+		}
+		series = append(series, seriesPoint{Date: date.AddDate(0, 0, t), Value: math.Exp(v) - 1})
+	}
+	return series
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6*math.Max(1, math.Abs(b))
+}
+
+func TestLeastSquares(t *testing.T) {
+	x := [][]float64{{1, 0}, {1, 1}, {1, 2}, {1, 3}}
+	y := []float64{1, 3, 5, 7}
+	b, ok := leastSquares(x, y)
+	if !ok || !near(b[0], 1) || !near(b[1], 2) {
+		t.Errorf("got %v %v", b, ok)
+	}
+
+	// 説明変数が一次従属の場合は解けない
+	if _, ok := leastSquares([][]float64{{1, 2}, {2, 4}, {3, 6}}, []float64{1, 2, 3}); ok {
+		t.Error("expected a singular system to fail")
+	}
+}
+
+func TestAnalyzeImpact(t *testing.T) {
+	date := time.Date(2021, 1, 8, 0, 0, 0, 0, time.UTC)
+	period := analyzeImpact(impactSeries(date, 28, 0.1, -0.5, -0.05), date)
+	if period == nil {
+		t.Fatal("expected a result")
+	}
+
+	if period.Before.Days != 28 || period.After.Days != 28 || !period.Before.To.Equal(date.AddDate(0, 0, -1)) || !period.After.From.Equal(date) {
+		t.Errorf("unexpected periods %+v %+v", period.Before, period.After)
+	}
+	if !near(period.Before.GrowthRate, math.Exp(0.1)-1) || !near(period.After.GrowthRate, math.Exp(-0.05)-1) {
+		t.Errorf("growth rates %v %v", period.Before.GrowthRate, period.After.GrowthRate)
+	}
+	if !near(period.GrowthRateChange, math.Exp(-0.05)-math.Exp(0.1)) {
+		t.Errorf("growth rate change %v", period.GrowthRateChange)
+	}
+	if !near(period.Before.Rt, math.Exp(0.1*generationTime)) || !near(period.RtChange, math.Exp(-0.05*generationTime)-math.Exp(0.1*generationTime)) {
+		t.Errorf("Rt %v, change %v", period.Before.Rt, period.RtChange)
+	}
+
+	its := period.ITS
+	if !near(its.LevelChange, math.Exp(-0.5)-1) || !near(its.SlopeChange, period.GrowthRateChange) {
+		t.Errorf("unexpected ITS %+v", its)
+	}
+	var expected float64
+	for d := 0; d < 28; d++ {
+		expected += math.Exp(5+0.1*float64(d)) - 1
+	}
+	if !near(its.ExpectedCases, expected) || !near(its.ObservedCases, period.After.Cases) {
+		t.Errorf("expected %v observed %v", its.ExpectedCases, its.ObservedCases)
+	}
+	if its.RelativeEffect >= 0 || !near(its.RelativeEffect, its.ObservedCases/its.ExpectedCases-1) {
+		t.Errorf("relative effect %v", its.RelativeEffect)
+	}
+}
+
+func TestAnalyzeImpactInsufficientData(t *testing.T) {
+	date := time.Date(2021, 1, 8, 0, 0, 0, 0, time.UTC)
+	series := impactSeries(date, 28, 0.1, 0, 0.1)
+
+	// 終了日が最新のデータより後 (後の期間が足りない)
+	if period := analyzeImpact(series[:28+minImpactDays-1], date); period != nil {
+		t.Errorf("expected nil, got %+v", period)
+	}
+	if period := analyzeImpact(series[28-minImpactDays:28+minImpactDays], date); period == nil {
+		t.Error("expected a result with minImpactDays on each side")
+	}
+}
+
+func TestLogCases(t *testing.T) {
+	if logCases(0) != 0 || logCases(-3) != 0 || !near(logCases(math.E-1), 1) {
+		t.Error("unexpected logCases")
+	}
+}
+
+// DB に問い合わせる前の検証 (400)
+func TestEventImpactInvalidRequests(t *testing.T) {
+	r := gin.New()
+	r.Use(errorMiddleware())
+	r.GET("/events/:id/impact", EventImpact)
+
+	tests := []struct {
+		path  string
+		param string
+	}{
+		{"/events/abc/impact", "id"},
+		{"/events/1/impact?window=3", "window"},
+		{"/events/1/impact?window=91", "window"},
+		{"/events/1/impact?window=x", "window"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var body struct {
+			Error apiError `json:"error"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != http.StatusBadRequest || body.Error.Param != tt.param {
+			t.Errorf("%s: got %d %s", tt.path, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// LOG_LEVEL (debug / info / warn / error) で変更する
+var logLevel = new(slog.LevelVar)
+
+var logger = newLogger(os.Stdout)
+
+// -------------
+// JSON 形式のログ
+// -------------
+
+func newLogger(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: logLevel}))
+}
+
+// LOG_LEVEL を読み込み、log パッケージの出力も JSON にする
+func configureLogging() {
+	if s := os.Getenv("LOG_LEVEL"); s != "" {
+		if err := logLevel.UnmarshalText([]byte(s)); err != nil {
+			logger.Warn("invalid LOG_LEVEL", "value", s, "error", err)
+		}
+	}
+	slog.SetDefault(logger)
+}
+
+// リクエストIDを付けたロガー
+func requestLogger(c *gin.Context) *slog.Logger {
+	return logger.With("request_id", c.GetString(requestIDKey))
+}
+
+// 1リクエストを1行で出力する。5xx は error、4xx は warn
+func loggingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		attrs := []slog.Attr{
+			slog.String("request_id", c.GetString(requestIDKey)),
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+			slog.Int("bytes", c.Writer.Size()),
+		}
+		if v, ok := c.Get(principalKey); ok {
+			attrs = append(attrs, slog.String("principal", v.(principal).Name))
+		}
+		// トレースと突き合わせられるようにする
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+		}
+		// レスポンスには出さない DB エラーなどの詳細もログには残す
+		if last := c.Errors.Last(); last != nil {
+			attrs = append(attrs, slog.String("error_code", toAPIError(last.Err).Code), slog.String("error", last.Err.Error()))
+		}
+
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		} else if status >= 400 {
+			level = slog.LevelWarn
+		}
+		logger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
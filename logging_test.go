@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// logger を差し替えてアクセスログを1件取り出す
+func captureRequestLog(t *testing.T, req *http.Request) (map[string]interface{}, *httptest.ResponseRecorder) {
+	t.Helper()
+	var buf bytes.Buffer
+	saved := logger
+	logger = newLogger(&buf)
+	defer func() { logger = saved }()
+
+	w := httptest.NewRecorder()
+	setupRouter().ServeHTTP(w, req)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log is not a single JSON line: %q", buf.String())
+	}
+	return entry, w
+}
+
+func TestLoggingMiddlewareJSON(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/v1/prefectures", nil)
+	req.Header.Set("X-Request-ID", "test-request-id")
+	entry, _ := captureRequestLog(t, req)
+
+	for key, want := range map[string]interface{}{
+		"level":      "INFO",
+		"msg":        "request",
+		"request_id": "test-request-id",
+		"method":     "GET",
+		"route":      "/api/v1/prefectures",
+		"path":       "/api/v1/prefectures",
+		"status":     float64(http.StatusOK),
+	} {
+		if entry[key] != want {
+			t.Errorf("%s = %v, want %v", key, entry[key], want)
+		}
+	}
+	for _, key := range []string{"latency_ms", "client_ip", "bytes"} {
+		if _, ok := entry[key]; !ok {
+			t.Errorf("%s is missing", key)
+		}
+	}
+}
+
+func TestLoggingMiddlewareError(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/no/such/path", nil)
+	entry, w := captureRequestLog(t, req)
+
+	if entry["level"] != "WARN" || entry["error_code"] != codeRouteNotFound {
+		t.Errorf("unexpected log %v", entry)
+	}
+	// 生成したリクエストIDはレスポンスとログで同じ
+	if id := w.Header().Get("X-Request-ID"); id == "" || entry["request_id"] != id {
+		t.Errorf("request_id = %v, header = %q", entry["request_id"], id)
+	}
+}
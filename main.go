@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -47,9 +51,9 @@ type Medical struct {
 }
 
 type infection struct {
-	Date      time.Time `json:"date"`
-	NameJp    string    `json:"name_jp"`
-	Npatients int       `json:"npatients"`
+	Date      time.Time `json:"date" csv:"日付"`
+	NameJp    string    `json:"name_jp" csv:"都道府県"`
+	Npatients int       `json:"npatients" csv:"感染者数"`
 }
 
 type diff_Npatients struct {
@@ -71,27 +75,20 @@ type diff_Npatients_Place_Per struct {
 	Message       string  `json:"message"`
 }
 
-type Event_JSON struct {
-	Title       string `json:"title" validate:"required"`
-	Description string `json:"description"`
-	Begin       string `json:"begin" validate:"required"`
-	End         string `json:"end" validate:"required"`
-}
-
 type Medicals struct {
-	FacilityName string `json:"facilityName"` // 病院名
-	FacilityAddr string `json:"facilityAddr"` // 場所
-	FacilityType string `json:"facilityType"` // 状況
+	FacilityName string `json:"facilityName" csv:"病院名"`
+	FacilityAddr string `json:"facilityAddr" csv:"場所"`
+	FacilityType string `json:"facilityType" csv:"状況"`
 }
 
 type Medicals_show struct {
-	FacilityName string `json:"facilityName"` // 病院名
-	ZipCode      string `json:"zipCode"`      // 郵便番号
-	CityName     string `json:"cityName"`     // 市町村
-	FacilityAddr string `json:"facilityAddr"` // 場所
-	FacilityTel  string `json:"facilityTel"`  // 電話番号
-	SubmitDate   string `json:"submitDate"`   // 日付
-	FacilityType string `json:"facilityType"` // 状況
+	FacilityName string `json:"facilityName" csv:"病院名"`
+	ZipCode      string `json:"zipCode" csv:"郵便番号"`
+	CityName     string `json:"cityName" csv:"市町村"`
+	FacilityAddr string `json:"facilityAddr" csv:"場所"`
+	FacilityTel  string `json:"facilityTel" csv:"電話番号"`
+	SubmitDate   string `json:"submitDate" csv:"日付"`
+	FacilityType string `json:"facilityType" csv:"状況"`
 }
 
 type Medical_count struct {
@@ -103,90 +100,176 @@ type Medical_count struct {
 }
 
 func main() {
+	configureLogging()
+
+	// SIGTERM (デプロイ時の再起動) では処理中のリクエストを終えてから停止する
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	err := run(ctx)
+	stop()
+	if err != nil {
+		logger.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// 設定を読み込んでサーバーを起動し、ctx が終了したら停止する
+func run(ctx context.Context) error {
+	if err := configureAuth(); err != nil {
+		return fmt.Errorf("configure authentication: %w", err)
+	}
+	if err := configureSecurity(); err != nil {
+		return fmt.Errorf("configure security: %w", err)
+	}
+	if err := configureRateLimit(); err != nil {
+		return fmt.Errorf("configure rate limiting: %w", err)
+	}
+	if err := configureTimeouts(); err != nil {
+		return fmt.Errorf("configure timeouts: %w", err)
+	}
+
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		return fmt.Errorf("set up tracing: %w", err)
+	}
+
+	s := newServer(listenAddr(), setupRouter())
+	s.OnStart("database", openDatabase) // すべてのハンドラーで共有する接続プール
+	s.OnStart("migrations", startMigrations)
+	s.OnStart("last_modified", func(ctx context.Context) error {
+		// DB に接続できない場合も起動する (ETag を付けないだけ)
+		if err := migrateLastModified(ctx); err != nil {
+			logger.Warn("failed to create last_modified table", "error", err)
+		}
+		lastModified() // ETag に使う更新日時を読み込んでおく
+		return nil
+	})
+	s.OnStop("tracing", shutdownTracing) // 未送信の span を送る
+	s.OnStop("database", closeDatabase)
+	return s.Run(ctx)
+}
+
+func setupRouter() *gin.Engine {
 	r := gin.New()
-	r.Use(loggingMiddleware())
+	// X-Forwarded-For は TRUSTED_PROXIES からのリクエストのみ信用する (ログ・流量制限の IP)
+	if err := r.SetTrustedProxies(security.trustedProxies); err != nil {
+		logger.Warn("invalid trusted proxies", "error", err)
+	}
+	r.Use(metricsMiddleware())          // リクエスト数と所要時間 (/metrics)
+	r.Use(tracingMiddleware())          // リクエスト・SQL・取り込みの span (OTEL_TRACES_EXPORTER)
+	r.Use(loggingMiddleware())          // JSON のアクセスログ (LOG_LEVEL)
+	r.Use(errorMiddleware())            // リクエストIDの付与とエラーレスポンスの統一 (panic もここで回復する)
+	r.Use(securityHeaders())            // HSTS・CSP などのヘッダー (contentSecurityPolicies)
+	r.Use(corsMiddleware())             // CORS_ALLOWED_ORIGINS からのブラウザのリクエストを許可する
+	r.Use(timeoutMiddleware())          // ルートごとの期限 (routeTimeouts)
+	r.Use(authFailureLimitMiddleware()) // 認証の失敗を IP ごとに制限する (authFailureCost)
+	r.Use(authMiddleware())             // API キー・JWT の検証 (書き込みは requireRole で権限を確認する)
+	r.Use(rateLimitMiddleware())        // キー・IP ごとの流量制限 (routeCosts)
+	r.HandleMethodNotAllowed = true
+	r.NoRoute(NoRoute)
+	r.NoMethod(NoMethod)
+	// ----------------------------------
+	// ダッシュボード
+	// ----------------------------------
+	r.GET("/", Dashboard)                       // 危険地帯マップ・感染者推移・病院検索の画面
+	r.StaticFS("/dashboard", dashboardAssets()) // 画面のJS/CSS
+	// ----------------------------------
+	// API仕様
+	// ----------------------------------
+	r.GET("/openapi.json", OpenAPI) // OpenAPI 3 の仕様
+	r.GET("/docs", SwaggerUI)       // Swagger UI
+	// ----------------------------------
+	// 運用
+	// ----------------------------------
+	r.GET("/healthz", Healthz)                                    // プロセスが動いているか
+	r.GET("/readyz", Readyz)                                      // DB に接続でき、テーブルがそろっているか
+	r.GET("/status", Status)                                      // 最新の感染者数の日付・最終取り込み日時・件数
+	r.GET("/debug/cache", requireRole(roleAdmin), CacheStats)     // 集計結果のキャッシュのヒット・ミス数
+	r.GET("/metrics", Metrics())                                  // Prometheus のメトリクス
+	r.GET("/admin/usage", requireRole(roleAdmin), RateLimitUsage) // クライアントごとのリクエスト数・429 の回数
+	// ----------------------------------
+	// API v1
+	// ----------------------------------
+	registerV1(r.Group("/api/v1"))
+	// 以下は旧URL (非推奨 Deprecation ヘッダーで /api/v1 の URL を案内する)
 	// ----------------------------------
 	// デフォルトで表示
 	// ----------------------------------
-	r.GET("/count/:date", CountOfPatients) // 日の感染者の合計
+	r.GET("/count/:date", deprecated("/api/v1/infections/{date}/total"), cached(infectionsCache), CountOfPatients) // 日の感染者の合計
 	// ----------------------------------
 	// 1
 	// ----------------------------------
-	r.GET("/firstfirst/:date", FirstFirst)   // 都道府県のマップを表示 色で危険地帯を視覚で把握可能 前々日比と前日比を算出して、前日比の方が多い場合、警告文字を変更する。その文字によって色を変える
-	r.GET("/firstsecond/:date", FirstSecond) // 都道府県のマップを表示 色で危険地帯を視覚で把握可能 前々日比と前日比を算出して、前日比の方が多い場合、警告文字を変更する。その文字によって色を変える
+	r.GET("/firstfirst/:date", deprecated("/api/v1/risk/{date}"), cached(infectionsCache), FirstFirst)         // 都道府県のマップを表示 色で危険地帯を視覚で把握可能 前々日比と前日比を算出して、前日比の方が多い場合、警告文字を変更する。その文字によって色を変える
+	r.GET("/firstsecond/:date", deprecated("/api/v1/risk/{date}/rates"), cached(infectionsCache), FirstSecond) // 都道府県のマップを表示 色で危険地帯を視覚で把握可能 前々日比と前日比を算出して、前日比の方が多い場合、警告文字を変更する。その文字によって色を変える
 	// ----------------------------------
 	// 2
 	// ----------------------------------
-	r.GET("/secondfirst/:place/:date", SecondFirst)       // ここ7日間の感染者推移
-	r.GET("/diffadd/:place/:date", DiffAdd)               // 前日比を表示
-	r.GET("/npatientsinmonth/:place/:date", SecondSecond) // 年月と都道府県を取得して、その月の感染者数推移を取得
-	r.GET("/npatientsinyear/:place/:date", SecondThird)   // 年と都道府県を取得して、その年の感染者推移を取得
+	r.GET("/secondfirst/:place/:date", deprecated("/api/v1/prefectures/{place}/recent/{date}"), cached(seriesCache), SecondFirst)           // ここ7日間の感染者推移
+	r.GET("/diffadd/:place/:date", deprecated("/api/v1/prefectures/{place}/diffs/{date}"), cached(infectionsCache), DiffAdd)                // 前日比を表示
+	r.GET("/npatientsinmonth/:place/:date", deprecated("/api/v1/prefectures/{place}/months/{date}"), cached(infectionsCache), SecondSecond) // 年月と都道府県を取得して、その月の感染者数推移を取得
+	r.GET("/npatientsinyear/:place/:date", deprecated("/api/v1/prefectures/{place}/years/{date}"), cached(infectionsCache), SecondThird)    // 年と都道府県を取得して、その年の感染者推移を取得
 	// ----------------------------------
 	// 3
 	// ----------------------------------
-	r.POST("/create", Create)                               // コロナに関するメモを追加
-	r.GET("/show/:id", Show)                                // コロナに関するメモを表示
-	r.GET("/shows", ShowAll)                                // コロナに関するメモを表示
-	r.PATCH("/show/:id", Update)                            // コロナに関するメモを変更
-	r.DELETE("/delete/:id", Delete)                         // コロナに関するメモを削除
-	r.GET("/getInfection/:date1/:date2", ThirdSecond)       // 期間を選択し、感染者を取得 47都道府県
-	r.GET("/getnpatients/:place/:date1/:date2", ThirdThird) // 期間を選択し、感染者を取得
+	r.POST("/create", deprecated("/api/v1/events"), requireRole(roleEditor), Create)                                                                       // コロナに関するメモを追加
+	r.GET("/show/:id", deprecated("/api/v1/events/{id}"), cached(eventsCache), Show)                                                                       // コロナに関するメモを表示
+	r.GET("/shows", deprecated("/api/v1/events"), cached(eventsCache), ShowAll)                                                                            // コロナに関するメモを表示
+	r.PATCH("/show/:id", deprecated("/api/v1/events/{id}"), requireRole(roleEditor), Update)                                                               // コロナに関するメモを変更
+	r.DELETE("/delete/:id", deprecated("/api/v1/events/{id}"), requireRole(roleEditor), Delete)                                                            // コロナに関するメモを削除
+	r.GET("/getInfection/:date1/:date2", deprecated("/api/v1/infections?from={date1}&to={date2}"), cached(infectionsCache), ThirdSecond)                   // 期間を選択し、感染者を取得 47都道府県
+	r.GET("/getnpatients/:place/:date1/:date2", deprecated("/api/v1/prefectures/{place}/series?from={date1}&to={date2}"), cached(seriesCache), ThirdThird) // 期間を選択し、感染者を取得
 	// ----------------------------------
 	// 4
 	// ----------------------------------
-	r.GET("/medicals/:place", ForthFirst)         //
-	r.GET("/medical/:hospital_name", ForthSecond) //
+	r.GET("/medicals/:place", deprecated("/api/v1/prefectures/{place}/facilities"), cached(medicalCache), ForthFirst)     //
+	r.GET("/medical/:hospital_name", deprecated("/api/v1/facilities/{hospital_name}"), cached(medicalCache), ForthSecond) //
 
 	// ----------------------------------
 	// 5
 	// ----------------------------------
-	r.GET("/hospital/:place/:status", FifthFirst) //
-	r.GET("/safearea/:date", FifthSecond)         //
+	r.GET("/hospital/:place/:status", deprecated("/api/v1/facilities?place={place}&status={status}"), cached(medicalCache), FifthFirst) //
+	r.GET("/safearea/:date", deprecated("/api/v1/risk/{date}/areas"), cached(areasCache), FifthSecond)                                  //
+	// ----------------------------------
+	// グラフ
+	// ----------------------------------
+	r.GET("/chart/:place", deprecated("/api/v1/prefectures/{place}/chart"), cached(chartCache), Chart) // 都道府県の感染者推移をPNG/SVGで描画 移動平均とメモを重ねて表示
 	// ----------------------------------
 	// データをimport
 	// ----------------------------------
-	r.POST("/import", Import)               // 都道府県感染者オープンAPIをimport
-	r.POST("/importmedical", ImportMedical) // 都道府県感染者オープンAPIをimport
+	r.POST("/import", deprecated("/api/v1/imports/infections"), requireRole(roleAdmin), Import)            // 都道府県感染者オープンAPIをimport
+	r.POST("/importmedical", deprecated("/api/v1/imports/medical"), requireRole(roleAdmin), ImportMedical) // 都道府県感染者オープンAPIをimport
 
-	r.Run()
-}
-
-func loggingMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		startTime := time.Now()
-		c.Next()
-		latency := time.Since(startTime)
-		fmt.Println(c.Request.Method, c.Request.URL.Path, c.Writer.Status())
-		time := fmt.Sprintf("%dms", latency/time.Millisecond)
-		log.Print(time)
-	}
+	return r
 }
 
 func CountOfPatients(c *gin.Context) {
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+	db, err := database()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
-	defer db.Close()
 
-	date, err := time.Parse("2006-01-02", c.Param("date"))
+	date, err := parseDate("date", c.Param("date"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}) // 400
+		abortWithError(c, err) // 400
 		return
 	}
 
-	var sum int
-	err = db.QueryRow("select sum(npatients) from infection where date = ?", date).Scan(&sum)
+	// その日のデータがなければ sum は NULL
+	var sum sql.NullInt64
+	err = db.QueryRowContext(c.Request.Context(), "select sum(npatients) from infection where date = ?", date).Scan(&sum)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
+		return
+	}
+	if !sum.Valid {
+		abortWithError(c, errNotFound("infection", "感染者数")) // 404
 		return
 	}
 
 	// 結果をJSONで出力
 	c.JSON(http.StatusOK, gin.H{
 		"date":      date,
-		"npatients": sum,
+		"npatients": sum.Int64,
 	})
 }
 
@@ -196,60 +279,44 @@ func CountOfPatients(c *gin.Context) {
 
 func FirstFirst(c *gin.Context) {
 
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+	db, err := database()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
-	defer db.Close()
 
-	date, err := time.Parse("2006-01-02", c.Param("date"))
+	date, err := parseDate("date", c.Param("date"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		abortWithError(c, err)
 		return
 	}
 
-	prevDate := date.AddDate(0, 0, -1)
-	prev2Date := date.AddDate(0, 0, -2)
-
-	infections := []diff_Npatients_Place{}
-	var wg sync.WaitGroup
+	// 同じ日の結果は取り込みまで変わらない
+	key := "FirstFirst:" + date.Format("2006-01-02")
+	if result, ok := analyticsCache.Get(key); ok {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+	generation := analyticsCache.Generation(sourceInfections) // 集計中に取り込まれた場合は保存しない
 
-	places := []string{"北海道", "青森県", "岩手県", "宮城県", "秋田県", "山形県", "福島県", "茨城県", "栃木県", "群馬県", "埼玉県", "千葉県", "東京都", "神奈川県", "新潟県", "富山県", "石川県", "福井県", "山梨県", "長野県", "岐阜県", "静岡県", "愛知県", "三重県", "滋賀県", "京都府", "大阪府", "兵庫県", "奈良県", "和歌山県", "鳥取県", "島根県", "岡山県", "広島県", "山口県", "徳島県", "香川県", "愛媛県", "高知県", "福岡県", "佐賀県", "長崎県", "熊本県", "大分県", "宮崎県", "鹿児島県", "沖縄県"}
-	for _, place := range places {
-		wg.Add(1)
-		go func(place string) {
-			defer wg.Done()
+	diffs, err := queryDailyDiffs(c.Request.Context(), db, date)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
 
-			npatients := diff_Npatients_Place{NameJp: place}
-
-			err = db.QueryRow("SELECT (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) - (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) as npatients", date, place, prevDate, place).Scan(&npatients.Npatients)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			err = db.QueryRow("SELECT (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) - (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) as npatients", prevDate, place, prev2Date, place).Scan(&npatients.NpatientsPrev)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-
-			culc := npatients.Npatients / npatients.NpatientsPrev * 100
-			if culc > 140 {
-				npatients.Message = "Too Danger"
-			} else if culc > 120 {
-				npatients.Message = "Danger"
-			} else if culc > 100 {
-				npatients.Message = "Warning"
-			} else if culc > 80 {
-				npatients.Message = "Caution"
-			} else {
-				npatients.Message = "attention"
-			}
-			infections = append(infections, npatients)
-		}(place)
+	infections := []diff_Npatients_Place{}
+	for _, d := range diffs {
+		npatients := diff_Npatients_Place{NameJp: d.Place, Npatients: d.Diff, NpatientsPrev: d.DiffPrev}
+		culc := 0
+		if npatients.NpatientsPrev != 0 {
+			culc = npatients.Npatients / npatients.NpatientsPrev * 100
+		}
+		npatients.Message = riskMessage(float64(culc))
+		infections = append(infections, npatients)
 	}
-	wg.Wait()
+
+	analyticsCache.Add(key, []string{sourceInfections}, generation, infections)
 
 	c.JSON(http.StatusOK, infections)
 }
@@ -260,65 +327,45 @@ func FirstFirst(c *gin.Context) {
 
 func FirstSecond(c *gin.Context) {
 
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+	db, err := database()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
-	defer db.Close()
 
-	date, err := time.Parse("2006-01-02", c.Param("date"))
+	date, err := parseDate("date", c.Param("date"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		abortWithError(c, err)
 		return
 	}
 
-	prevDate := date.AddDate(0, 0, -1)
-	prev2Date := date.AddDate(0, 0, -2)
-
-	infections := []diff_Npatients_Place_Per{}
-	var wg sync.WaitGroup
+	// 同じ日の結果は取り込みまで変わらない
+	key := "FirstSecond:" + date.Format("2006-01-02")
+	if result, ok := analyticsCache.Get(key); ok {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+	generation := analyticsCache.Generation(sourceInfections) // 集計中に取り込まれた場合は保存しない
 
-	places := []string{"北海道", "青森県", "岩手県", "宮城県", "秋田県", "山形県", "福島県", "茨城県", "栃木県", "群馬県", "埼玉県", "千葉県", "東京都", "神奈川県", "新潟県", "富山県", "石川県", "福井県", "山梨県", "長野県", "岐阜県", "静岡県", "愛知県", "三重県", "滋賀県", "京都府", "大阪府", "兵庫県", "奈良県", "和歌山県", "鳥取県", "島根県", "岡山県", "広島県", "山口県", "徳島県", "香川県", "愛媛県", "高知県", "福岡県", "佐賀県", "長崎県", "熊本県", "大分県", "宮崎県", "鹿児島県", "沖縄県"}
-	for _, place := range places {
-		wg.Add(1)
-		go func(place string) {
-			defer wg.Done()
+	diffs, err := queryDailyDiffs(c.Request.Context(), db, date)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
 
-			npatients := diff_Npatients_Place_Per{NameJp: place}
-
-			err = db.QueryRow("SELECT (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) - (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) as npatients", date, place, prevDate, place).Scan(&npatients.Npatients)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			err = db.QueryRow("SELECT (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) - (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) as npatients", prevDate, place, prev2Date, place).Scan(&npatients.NpatientsPrev)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-
-			per := npatients.Npatients / npatients.NpatientsPrev * 100
-			s := strconv.Itoa(int(per))
-			npatients.Per = s + "%"
-
-			culc := npatients.Npatients / npatients.NpatientsPrev * 100
-			if culc > 140 {
-				npatients.Message = "Too Danger"
-			} else if culc > 120 {
-				npatients.Message = "Danger"
-			} else if culc > 100 {
-				npatients.Message = "Warning"
-			} else if culc > 80 {
-				npatients.Message = "Caution"
-			} else {
-				npatients.Message = "attention"
-			}
-			infections = append(infections, npatients)
-		}(place)
+	infections := []diff_Npatients_Place_Per{}
+	for _, d := range diffs {
+		npatients := diff_Npatients_Place_Per{NameJp: d.Place, Npatients: float64(d.Diff), NpatientsPrev: float64(d.DiffPrev)}
+		var per float64
+		if npatients.NpatientsPrev != 0 {
+			per = npatients.Npatients / npatients.NpatientsPrev * 100
+		}
+		npatients.Per = strconv.Itoa(int(per)) + "%"
+		npatients.Message = riskMessage(per)
+		infections = append(infections, npatients)
 	}
 
-	wg.Wait()
+	analyticsCache.Add(key, []string{sourceInfections}, generation, infections)
 
 	c.JSON(http.StatusOK, infections)
 }
@@ -328,16 +375,20 @@ func FirstSecond(c *gin.Context) {
 // -------------
 
 func SecondFirst(c *gin.Context) {
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+	db, err := database()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
-	defer db.Close()
 
-	date, err := time.Parse("2006-01-02", c.Param("date"))
+	date, err := parseDate("date", c.Param("date"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}) // 400
+		abortWithError(c, err) // 400
+		return
+	}
+	withEvents, err := includeEvents(c)
+	if err != nil {
+		abortWithError(c, err) // 400
 		return
 	}
 	prevDates := []time.Time{
@@ -350,99 +401,96 @@ func SecondFirst(c *gin.Context) {
 		date.AddDate(0, 0, -7),
 	}
 
-	place := c.Param("place")
-	var infections []infection
-	var wg sync.WaitGroup
+	pref, err := parsePlace("place", c.Param("place"))
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
+	}
+	place := pref.NameJp
+
+	// 前日から7日前まで (新しい順)
+	infections := make([]infection, len(prevDates))
+	errs := make([]error, len(prevDates))
 
-	for _, prevDate := range prevDates {
+	var wg sync.WaitGroup
+	for i := range prevDates {
 		wg.Add(1)
-		go func(prevDate time.Time) {
+		go func(i int) {
 			defer wg.Done()
-			var infection infection
-			err = db.QueryRow("SELECT date, name_jp, npatients FROM infection WHERE name_jp = ? and date = ?", place, prevDate).Scan(&infection.Date, &infection.NameJp, &infection.Npatients)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}) // 500
-				return
-			}
-			infections = append(infections, infection)
-		}(prevDate)
+			errs[i] = db.QueryRowContext(c.Request.Context(), "SELECT date, name_jp, npatients FROM infection WHERE name_jp = ? and date = ?", place, prevDates[i]).Scan(&infections[i].Date, &infections[i].NameJp, &infections[i].Npatients)
+		}(i)
 	}
-
 	wg.Wait()
+
+	for _, err := range errs {
+		if errors.Is(err, sql.ErrNoRows) {
+			abortWithError(c, errNotFound("infection", "感染者数")) // 404 (7日間のどこかのデータがない)
+			return
+		}
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+	}
+
+	if withEvents {
+		events, err := queryPrefectureEvents(c.Request.Context(), db, pref, prevDates[len(prevDates)-1], prevDates[0])
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"infections": infections, "events": events})
+		return
+	}
 	c.JSON(http.StatusOK, infections)
 }
 
 func DiffAdd(c *gin.Context) {
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+	db, err := database()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
-	defer db.Close()
 
-	place := c.Param("place")
-	date, err := time.Parse("2006-01-02", c.Param("date"))
+	pref, err := parsePlace("place", c.Param("place"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}) // 400
+		abortWithError(c, err) // 400
+		return
+	}
+	place := pref.NameJp
+	date, err := parseDate("date", c.Param("date"))
+	if err != nil {
+		abortWithError(c, err) // 400
 		return
 	}
 
-	prevDate := date.AddDate(0, 0, -1)
-	prev2Date := date.AddDate(0, 0, -2)
-	prev3Date := date.AddDate(0, 0, -3)
-	prev4Date := date.AddDate(0, 0, -4)
-	prev5Date := date.AddDate(0, 0, -5)
-	prev6Date := date.AddDate(0, 0, -6)
-
-	var diff1, diff2, diff3, diff4, diff5, diff6 diff_Npatients
+	// 当日から6日前までの前日比。当日か前日のデータがなければ差は NULL
+	infections := make([]diff_Npatients, 6)
+	diffs := make([]sql.NullInt64, 6)
+	errs := make([]error, 6)
 
 	var wg sync.WaitGroup
-	wg.Add(6)
-	go func() {
-		err = db.QueryRow("SELECT (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) - (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) as npatients", date, place, prevDate, place).Scan(&diff1.Npatients)
-		if err != nil {
-			log.Fatal(err)
-		}
-		wg.Done()
-	}()
-	go func() {
-		err = db.QueryRow("SELECT (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) - (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) as npatients", prevDate, place, prev2Date, place).Scan(&diff2.Npatients)
-		if err != nil {
-			log.Fatal(err)
-		}
-		wg.Done()
-	}()
-	go func() {
-		err = db.QueryRow("SELECT (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) - (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) as npatients", prev2Date, place, prev3Date, place).Scan(&diff3.Npatients)
-		if err != nil {
-			log.Fatal(err)
-		}
-		wg.Done()
-	}()
-	go func() {
-		err = db.QueryRow("SELECT (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) - (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) as npatients", prev3Date, place, prev4Date, place).Scan(&diff4.Npatients)
-		if err != nil {
-			log.Fatal(err)
-		}
-		wg.Done()
-	}()
-	go func() {
-		err = db.QueryRow("SELECT (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) - (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) as npatients", prev4Date, place, prev5Date, place).Scan(&diff5.Npatients)
+	for i := range infections {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			day := date.AddDate(0, 0, -i)
+			errs[i] = db.QueryRowContext(c.Request.Context(), "SELECT (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) - (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) as npatients", day, place, day.AddDate(0, 0, -1), place).Scan(&diffs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
 		if err != nil {
-			log.Fatal(err)
+			abortWithError(c, err)
+			return
 		}
-		wg.Done()
-	}()
-	go func() {
-		err = db.QueryRow("SELECT (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) - (SELECT npatients FROM infection WHERE date = ? AND name_jp = ?) as npatients", prev5Date, place, prev6Date, place).Scan(&diff6.Npatients)
-		if err != nil {
-			log.Fatal(err)
+		if !diffs[i].Valid {
+			abortWithError(c, errNotFound("infection", "感染者数")) // 404
+			return
 		}
-		wg.Done()
-	}()
-	wg.Wait()
-
-	infections := []diff_Npatients{diff1, diff2, diff3, diff4, diff5, diff6}
+		infections[i].Npatients = int(diffs[i].Int64)
+	}
 
 	c.JSON(http.StatusOK, infections)
 }
@@ -452,28 +500,43 @@ func DiffAdd(c *gin.Context) {
 // -------------
 
 func SecondSecond(c *gin.Context) {
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+	db, err := database()
 	if err != nil {
-		log.Fatal(err)
+		abortWithError(c, err)
+		return
 	}
-	defer db.Close()
 
-	date := c.Param("date")
-	place := c.Param("place")
+	place, err := parsePlace("place", c.Param("place"))
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
+	}
+	begin, end, err := parseMonth("date", c.Param("date"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
 
-	rows, err := db.Query("select date, name_jp, npatients from infection where name_jp = ? and date like ? ORDER BY date ASC", place, date+"%")
+	rows, err := db.QueryContext(c.Request.Context(), "select date, name_jp, npatients from infection where name_jp = ? and date between ? and ? ORDER BY date ASC", place.NameJp, begin, end)
 	if err != nil {
-		log.Fatal(err)
+		abortWithError(c, err)
+		return
 	}
+	defer rows.Close()
 	var resultInfection []infection
 
 	for rows.Next() {
 		infection := infection{}
 		if err := rows.Scan(&infection.Date, &infection.NameJp, &infection.Npatients); err != nil {
-			log.Fatal(err)
+			abortWithError(c, err)
+			return
 		}
 		resultInfection = append(resultInfection, infection)
 	}
+	if err := rows.Err(); err != nil {
+		abortWithError(c, err)
+		return
+	}
 
 	c.JSON(http.StatusOK, resultInfection)
 
@@ -485,489 +548,661 @@ func SecondSecond(c *gin.Context) {
 
 func SecondThird(c *gin.Context) {
 	// Connect to the database
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+	db, err := database()
 	if err != nil {
-		log.Fatal(err)
+		abortWithError(c, err)
+		return
 	}
-	defer db.Close()
 
-	date := c.Param("date")
-	place := c.Param("place")
+	place, err := parsePlace("place", c.Param("place"))
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
+	}
+	begin, end, err := parseYear("date", c.Param("date"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
 
-	rows, err := db.Query("select date, name_jp, npatients from infection where name_jp = ? and date like ? order by date ASC", place, date+"%")
+	rows, err := db.QueryContext(c.Request.Context(), "select date, name_jp, npatients from infection where name_jp = ? and date between ? and ? order by date ASC", place.NameJp, begin, end)
 	if err != nil {
-		log.Fatal(err)
+		abortWithError(c, err)
+		return
 	}
+	defer rows.Close()
 
 	var resultInfection []infection
 
 	for rows.Next() {
 		infection := infection{}
 		if err := rows.Scan(&infection.Date, &infection.NameJp, &infection.Npatients); err != nil {
-			log.Fatal(err)
+			abortWithError(c, err)
+			return
 		}
 		resultInfection = append(resultInfection, infection)
 	}
+	if err := rows.Err(); err != nil {
+		abortWithError(c, err)
+		return
+	}
 
 	c.JSON(http.StatusOK, resultInfection)
 }
 
 // -------------
-// 3 - 1
+// 3 - 2
 // -------------
 
-func Create(c *gin.Context) {
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+func ThirdSecond(c *gin.Context) {
+	db, err := database()
 	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
-	var json Event_JSON
-	validate := Validate() //インスタンス生成
-
-	if err := c.ShouldBindJSON(&json); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 
-	// yyyymmdd形式の文字列をtime.Time型に変換
-	layout := "2006-01-02"
-	t, err := time.Parse(layout, json.Begin)
+	format, err := exportFormat(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, err) // 400
 		return
 	}
 
-	// yyyymmdd形式の文字列をtime.Time型に変換
-	t2, err := time.Parse(layout, json.End)
+	date1, date2, err := parseDateRange("date1", c.Param("date1"), "date2", c.Param("date2"), maxRangeDays)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, err) // 400
 		return
 	}
 
-	if err = validate.Struct(&json); err != nil { //バリデーションを実行し、NGの場合、ここでエラーが返る。
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-	}
-
-	insert, err := db.Prepare("INSERT INTO events (title, description, begin, end) VALUES (?, ?, ?, ?)")
+	pg, err := newPager(c, infectionSorts, infection{}, format == "json")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err) // 400
 		return
 	}
-	defer insert.Close()
 
-	// パラメータを設定してクエリを実行
-	_, err = insert.Exec(json.Title, json.Description, t.Format("2006-01-02"), t2.Format("2006-01-02"))
+	clause, args := pg.clause("date between ? and ?", date1, date2)
+	rows, err := db.QueryContext(c.Request.Context(), "select date, name_jp, npatients"+pg.keys()+" from infection"+clause, args...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-	}
-}
-
-func Show(c *gin.Context) {
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}) // 500
+		abortWithError(c, err)
 		return
 	}
-	defer db.Close()
+	defer rows.Close()
 
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}) // 400
-		return
-	}
+	ex := newExporter(c, format, "infections", infection{})
+	var resultInfection []infection
 
-	var json Event_JSON
-	err = db.QueryRow("SELECT title, description, begin, end FROM events WHERE id = ?", id).Scan(&json.Title, &json.Description, &json.Begin, &json.End)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "event not found"}) // 404
+	for rows.Next() {
+		infection := infection{}
+		ok, err := pg.Scan(rows, &infection.Date, &infection.NameJp, &infection.Npatients)
+		if err != nil {
+			abortWithError(c, err)
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		if !ok {
+			break
+		}
+		if ex != nil {
+			ex.Write(infection)
+			continue
+		}
+		resultInfection = append(resultInfection, infection)
 	}
-
-	c.JSON(http.StatusOK, json)
-}
-
-func ShowAll(c *gin.Context) {
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}) // 500
+	if err := rows.Err(); err != nil {
+		abortWithError(c, err)
 		return
 	}
-	defer db.Close()
 
-	rows, err := db.Query("SELECT title, description, begin, end FROM events")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}) // 500
+	if ex != nil {
+		if err := ex.Close(); err != nil {
+			c.Error(err)
+		}
 		return
 	}
-	defer rows.Close()
 
-	var result []Event_JSON
-	for rows.Next() {
-		var json Event_JSON
-		if err := rows.Scan(&json.Title, &json.Description, &json.Begin, &json.End); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}) // 500
-			return
-		}
-		result = append(result, json)
-	}
+	pg.JSON(c, resultInfection)
 
-	c.JSON(http.StatusOK, result) // 200
 }
 
-func Update(c *gin.Context) {
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}) // 500
-		return
-	}
-	defer db.Close()
-
-	var json Event_JSON
-	if err := c.ShouldBindJSON(&json); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"}) // 400
-		return
-	}
+// -------------
+// 3 - 3
+// -------------
 
-	id, err := strconv.Atoi(c.Param("id"))
+func ThirdThird(c *gin.Context) {
+	db, err := database()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}) // 400
+		abortWithError(c, err)
 		return
 	}
 
-	update, err := db.Prepare("UPDATE events SET title = ?, description = ?, begin = ?, end = ? WHERE id = ?")
+	format, err := exportFormat(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}) // 500
+		abortWithError(c, err) // 400
 		return
 	}
-	if _, err := update.Exec(json.Title, json.Description, json.Begin, json.End, id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}) // 500
-		return
-	}
-
-	c.Status(http.StatusOK) // 200
-}
 
-func Delete(c *gin.Context) {
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+	place, err := parsePlace("place", c.Param("place"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}) // 500
+		abortWithError(c, err) // 400
 		return
 	}
-	defer db.Close()
-
-	id, err := strconv.Atoi(c.Param("id"))
+	date1, date2, err := parseDateRange("date1", c.Param("date1"), "date2", c.Param("date2"), maxPlaceRangeDays)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}) // 400
+		abortWithError(c, err)
 		return
 	}
 
-	delete, err := db.Prepare("DELETE FROM events WHERE id = ?")
+	pg, err := newPager(c, infectionSorts, infection{}, format == "json")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}) // 500
+		abortWithError(c, err) // 400
 		return
 	}
-	if _, err := delete.Exec(id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}) // 500
+	withEvents, err := includeEvents(c)
+	if err != nil {
+		abortWithError(c, err) // 400
 		return
 	}
 
-	c.Status(http.StatusOK) // 200
-}
-
-// -------------
-// 3 - 2
-// -------------
-
-func ThirdSecond(c *gin.Context) {
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+	clause, args := pg.clause("name_jp = ? and date between ? and ?", place.NameJp, date1, date2)
+	rows, err := db.QueryContext(c.Request.Context(), "select date, name_jp, npatients"+pg.keys()+" from infection"+clause, args...)
 	if err != nil {
-		log.Fatal(err)
+		abortWithError(c, err)
+		return
 	}
-	defer db.Close()
-
-	date1 := c.Param("date1")
-	date2 := c.Param("date2")
+	defer rows.Close()
 
-	rows, err := db.Query("select date, name_jp, npatients from infection where date between ? and ? order by date ASC", date1, date2)
-	if err != nil {
-		log.Fatal(err)
-	}
+	ex := newExporter(c, format, "infections", infection{})
 	var resultInfection []infection
 
 	for rows.Next() {
 		infection := infection{}
-		if err := rows.Scan(&infection.Date, &infection.NameJp, &infection.Npatients); err != nil {
-			log.Fatal(err)
+		ok, err := pg.Scan(rows, &infection.Date, &infection.NameJp, &infection.Npatients)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if !ok {
+			break
+		}
+		if ex != nil {
+			ex.Write(infection)
+			continue
 		}
 		resultInfection = append(resultInfection, infection)
 	}
+	if err := rows.Err(); err != nil {
+		abortWithError(c, err)
+		return
+	}
 
-	c.JSON(http.StatusOK, resultInfection)
+	if ex != nil {
+		if err := ex.Close(); err != nil {
+			c.Error(err)
+		}
+		return
+	}
 
-}
+	// CSV・XLSX にはメモを含めない
+	if withEvents {
+		events, err := queryPrefectureEvents(c.Request.Context(), db, place, date1, date2)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		pg.Link(c)
+		c.JSON(http.StatusOK, gin.H{"infections": pg.Fields(resultInfection), "events": events})
+		return
+	}
+	pg.JSON(c, resultInfection)
 
-// -------------
-// 3 - 3
-// -------------
+}
 
-func ThirdThird(c *gin.Context) {
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+func ForthFirst(c *gin.Context) {
+	db, err := database()
 	if err != nil {
-		log.Fatal(err)
+		abortWithError(c, err)
+		return
 	}
-	defer db.Close()
 
-	place := c.Param("place")
-	date1 := c.Param("date1")
-	date2 := c.Param("date2")
-
-	rows, err := db.Query("select date, name_jp, npatients from infection where name_jp = ? and date between ? and ?;", place, date1, date2)
+	format, err := exportFormat(c)
 	if err != nil {
-		log.Fatal(err)
+		abortWithError(c, err) // 400
+		return
 	}
-	var resultInfection []infection
 
-	for rows.Next() {
-		infection := infection{}
-		if err := rows.Scan(&infection.Date, &infection.NameJp, &infection.Npatients); err != nil {
-			log.Fatal(err)
-		}
-		resultInfection = append(resultInfection, infection)
+	pref, err := parsePlace("place", c.Param("place"))
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
 	}
+	place := pref.NameJp
 
-	c.JSON(http.StatusOK, resultInfection)
-
-}
-
-func ForthFirst(c *gin.Context) {
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+	pg, err := newPager(c, medicalSorts, Medicals{}, format == "json")
 	if err != nil {
-		log.Fatal(err)
+		abortWithError(c, err) // 400
+		return
 	}
-	defer db.Close()
 
-	place := c.Param("place")
-
-	rows, err := db.Query("select facility_name, facility_addr, facility_type from medical where pref_name = ?", place)
+	clause, args := pg.clause("pref_name = ?", place)
+	rows, err := db.QueryContext(c.Request.Context(), "select facility_name, facility_addr, facility_type"+pg.keys()+" from medical"+clause, args...)
 	if err != nil {
-		log.Fatal(err)
+		abortWithError(c, err)
+		return
 	}
+	defer rows.Close()
+
+	ex := newExporter(c, format, "medicals", Medicals{})
 	var resultMedical []Medicals
 
 	for rows.Next() {
 		medical := Medicals{}
-		if err := rows.Scan(&medical.FacilityName, &medical.FacilityAddr, &medical.FacilityType); err != nil {
-			log.Fatal(err)
+		ok, err := pg.Scan(rows, &medical.FacilityName, &medical.FacilityAddr, &medical.FacilityType)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if !ok {
+			break
+		}
+		if ex != nil {
+			ex.Write(medical)
+			continue
 		}
 		resultMedical = append(resultMedical, medical)
 	}
+	if err := rows.Err(); err != nil {
+		abortWithError(c, err)
+		return
+	}
 
-	c.JSON(http.StatusOK, resultMedical)
+	if ex != nil {
+		if err := ex.Close(); err != nil {
+			c.Error(err)
+		}
+		return
+	}
+
+	pg.JSON(c, resultMedical)
 }
 
 func ForthSecond(c *gin.Context) {
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+	db, err := database()
 	if err != nil {
-		log.Fatal(err)
+		abortWithError(c, err)
+		return
 	}
-	defer db.Close()
 
 	hospital_name := c.Param("hospital_name")
 
 	var medical Medicals_show
 
-	err = db.QueryRow("select facility_name, zip_code, facility_addr, facility_tel, submit_date, facility_type, city_name from medical where facility_name = ?", hospital_name).Scan(&medical.FacilityName, &medical.ZipCode, &medical.FacilityAddr, &medical.FacilityTel, &medical.SubmitDate, &medical.FacilityType, &medical.CityName)
+	err = db.QueryRowContext(c.Request.Context(), "select facility_name, zip_code, facility_addr, facility_tel, submit_date, facility_type, city_name from medical where facility_name = ?", hospital_name).Scan(&medical.FacilityName, &medical.ZipCode, &medical.FacilityAddr, &medical.FacilityTel, &medical.SubmitDate, &medical.FacilityType, &medical.CityName)
 	if err != nil {
-		log.Fatal(err)
+		if err == sql.ErrNoRows {
+			abortWithError(c, errNotFound("hospital", "病院")) // 404
+			return
+		}
+		abortWithError(c, err)
+		return
 	}
 
 	c.JSON(http.StatusOK, medical)
 }
 
 func FifthFirst(c *gin.Context) {
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+	db, err := database()
 	if err != nil {
-		log.Fatal(err)
+		abortWithError(c, err)
+		return
+	}
+
+	format, err := exportFormat(c)
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
 	}
-	defer db.Close()
 
 	place := c.Param("place")
 	status := c.Param("status")
 
-	rows, err := db.Query("select facility_name, zip_code, facility_addr, facility_tel, submit_date, facility_type, city_name from medical where facility_addr like ? and facility_type = ?", place+"%", status)
+	pg, err := newPager(c, hospitalSorts, Medicals_show{}, format == "json")
+	if err != nil {
+		abortWithError(c, err) // 400
+		return
+	}
+
+	clause, args := pg.clause("facility_addr like ? and facility_type = ?", place+"%", status)
+	rows, err := db.QueryContext(c.Request.Context(), "select facility_name, zip_code, facility_addr, facility_tel, submit_date, facility_type, city_name"+pg.keys()+" from medical"+clause, args...)
 	if err != nil {
-		log.Fatal(err)
+		abortWithError(c, err)
+		return
 	}
+	defer rows.Close()
+
+	ex := newExporter(c, format, "hospitals", Medicals_show{})
 	var resultMedical []Medicals_show
 
 	for rows.Next() {
 		medical := Medicals_show{}
-		if err := rows.Scan(&medical.FacilityName, &medical.ZipCode, &medical.FacilityAddr, &medical.FacilityTel, &medical.SubmitDate, &medical.FacilityType, &medical.CityName); err != nil {
-			log.Fatal(err)
+		ok, err := pg.Scan(rows, &medical.FacilityName, &medical.ZipCode, &medical.FacilityAddr, &medical.FacilityTel, &medical.SubmitDate, &medical.FacilityType, &medical.CityName)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if !ok {
+			break
+		}
+		if ex != nil {
+			ex.Write(medical)
+			continue
 		}
 		resultMedical = append(resultMedical, medical)
 	}
+	if err := rows.Err(); err != nil {
+		abortWithError(c, err)
+		return
+	}
 
-	c.JSON(http.StatusOK, resultMedical)
+	if ex != nil {
+		if err := ex.Close(); err != nil {
+			c.Error(err)
+		}
+		return
+	}
+
+	pg.JSON(c, resultMedical)
 }
 
 func FifthSecond(c *gin.Context) {
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+	db, err := database()
 	if err != nil {
-		log.Fatal(err)
+		abortWithError(c, err)
+		return
 	}
-	defer db.Close()
 
-	date, err := time.Parse("2006-01-02", c.Param("date"))
+	date, err := parseDate("date", c.Param("date"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		abortWithError(c, err)
 		return
 	}
 
-	prefNames := []string{"北海道", "青森県", "岩手県", "宮城県", "秋田県", "山形県", "福島県", "茨城県", "栃木県", "群馬県", "埼玉県", "千葉県", "東京都", "神奈川県", "新潟県", "富山県", "石川県", "福井県", "山梨県", "長野県", "岐阜県", "静岡県", "愛知県", "三重県", "滋賀県", "京都府", "大阪府", "兵庫県", "奈良県", "和歌山県", "鳥取県", "島根県", "岡山県", "広島県", "山口県", "徳島県", "香川県", "愛媛県", "高知県", "福岡県", "佐賀県", "長崎県", "熊本県", "大分県", "宮崎県", "鹿児島県", "沖縄県"}
-	resultChan := make(chan Medical_count, len(prefNames))
+	// 同じ日の結果は取り込みまで変わらない
+	key := "FifthSecond:" + date.Format("2006-01-02")
+	if result, ok := analyticsCache.Get(key); ok {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+	generation := analyticsCache.Generation(sourceInfections, sourceMedical) // 集計中に取り込まれた場合は保存しない
 
-	for _, prefName := range prefNames {
-		go func(prefName string) {
-			var count, npatients int
-			err = db.QueryRow("select count(*) from medical where pref_name = ?", prefName).Scan(&count)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			err = db.QueryRow("select npatients from infection where name_jp = ? and date = ?", prefName, date).Scan(&npatients)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			// 病床使用率を57%として計算 https://stopcovid19.metro.tokyo.lg.jp/
-			per := npatients / count * 57 / 100
-			p := strconv.Itoa(int(per))
-			var message string
-			if per > 1000 {
-				message = "Too Danger Area"
-			} else if per > 700 {
-				message = "Danger Area"
-			} else if per > 400 {
-				message = "Warning Area"
-			} else if per > 100 {
-				message = "Caution Area"
-			} else {
-				message = "attention Area"
-			}
-			resultChan <- Medical_count{Place: prefName, HospitalCount: count, Npatients: npatients, Per: p, Message: message}
+	// 都道府県ごとの感染者数と病院数
+	rows, err := db.QueryContext(c.Request.Context(), "select i.name_jp, i.npatients, count(m.facility_id) from infection i left join medical m on m.pref_name = i.name_jp where i.date = ? group by i.name_jp, i.npatients", date)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	defer rows.Close()
 
-			// }
-		}(prefName)
+	counts := map[string]Medical_count{}
+	for rows.Next() {
+		var m Medical_count
+		if err := rows.Scan(&m.Place, &m.Npatients, &m.HospitalCount); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		counts[m.Place] = m
+	}
+	if err := rows.Err(); err != nil {
+		abortWithError(c, err)
+		return
 	}
 
-	result := make([]Medical_count, 0)
-	for i := 0; i < len(prefNames); i++ {
-		result = append(result, <-resultChan)
+	// 都道府県コード順 (感染者数のない都道府県は除く)
+	result := make([]Medical_count, 0, len(prefectures))
+	for _, pref := range prefectures {
+		if m, ok := counts[pref.NameJp]; ok {
+			result = append(result, areaRisk(m))
+		}
+	}
+	if len(result) == 0 {
+		abortWithError(c, errNotFound("infection", "感染者数")) // 404
+		return
 	}
 
+	analyticsCache.Add(key, []string{sourceInfections, sourceMedical}, generation, result)
+
 	c.JSON(http.StatusOK, result)
 }
 
+// 前日比・前々日比 (新規感染者数)
+type dailyDiff struct {
+	Place    string
+	Diff     int // date の新規感染者数
+	DiffPrev int // date の前日の新規感染者数
+}
+
+// date の2日前から date までの累計をまとめて取得し、都道府県ごとの新規感染者数を都道府県コード順に返す
+func queryDailyDiffs(ctx context.Context, db *sql.DB, date time.Time) ([]dailyDiff, error) {
+	var places []string
+	for _, pref := range prefectures {
+		places = append(places, pref.NameJp)
+	}
+	cumulative, err := queryCumulative(ctx, db, places, date.AddDate(0, 0, -2), date)
+	if err != nil {
+		return nil, err
+	}
+	return dailyDiffs(places, cumulative)
+}
+
+func dailyDiffs(places []string, cumulative map[string][]seriesPoint) ([]dailyDiff, error) {
+	var result []dailyDiff
+	for _, place := range places {
+		// 3日分そろっていない都道府県は除く
+		if s := cumulative[place]; len(s) == 3 {
+			result = append(result, dailyDiff{Place: place, Diff: int(s[2].Value - s[1].Value), DiffPrev: int(s[1].Value - s[0].Value)})
+		}
+	}
+	if len(result) == 0 {
+		return nil, errNotFound("infection", "感染者数") // 404
+	}
+	return result, nil
+}
+
+// 前日比 (%) による警告文字
+func riskMessage(culc float64) string {
+	if culc > 140 {
+		return "Too Danger"
+	} else if culc > 120 {
+		return "Danger"
+	} else if culc > 100 {
+		return "Warning"
+	} else if culc > 80 {
+		return "Caution"
+	}
+	return "attention"
+}
+
+// 病院数と感染者数による危険度 (病院がない場合は 0 とする)
+func areaRisk(m Medical_count) Medical_count {
+	var per int
+	if m.HospitalCount != 0 {
+		// 病床使用率を57%として計算 https://stopcovid19.metro.tokyo.lg.jp/
+		per = m.Npatients / m.HospitalCount * 57 / 100
+	}
+	m.Per = strconv.Itoa(per)
+	if per > 1000 {
+		m.Message = "Too Danger Area"
+	} else if per > 700 {
+		m.Message = "Danger Area"
+	} else if per > 400 {
+		m.Message = "Warning Area"
+	} else if per > 100 {
+		m.Message = "Caution Area"
+	} else {
+		m.Message = "attention Area"
+	}
+	return m
+}
+
 func Validate() *validator.Validate {
 	validate := validator.New()
 	return validate
 }
 
 func Import(c *gin.Context) {
-	log.Print("データ取り込み中")
+	requestLogger(c).Info("データ取り込み中", "dataset", sourceInfections)
+	defer observeImport(c, sourceInfections, time.Now())
+	phases := newImportTrace(c)
+	defer phases.End()
+
+	phases.Phase("fetch")
 	url := "https://opendata.corona.go.jp/api/Covid19JapanAll"
-	resp, _ := http.Get(url)
-	if resp != nil {
-		defer resp.Body.Close()
+	resp, err := fetch(c.Request.Context(), url)
+	if err != nil {
+		abortWithError(c, errUpstream(err))
+		return
+	}
+	defer resp.Body.Close()
+	byteArray, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		abortWithError(c, errUpstream(err))
+		return
 	}
-	byteArray, _ := ioutil.ReadAll(resp.Body)
 
+	phases.Phase("decode")
 	jsonBytes := ([]byte)(byteArray)
 	data := new(Npatients)
 
 	if err := json.Unmarshal(jsonBytes, data); err != nil {
-		fmt.Println("JSON Unmarshal error:", err)
+		abortWithError(c, errUpstream(err))
 		return
 	}
 
-	// db, err := sql.Open("mysql", "root:password@(db:3306)/training?parseTime=true")
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+	phases.Phase("write")
+	db, err := database()
 	if err != nil {
-		log.Fatal(err)
+		abortWithError(c, err)
+		return
 	}
-	defer db.Close()
 
-	delete, err := db.Prepare("DELETE FROM infection")
+	// 途中で中断した場合に空のテーブルが残らないよう、入れ替えを1つのトランザクションで行う
+	tx, err := db.BeginTx(c.Request.Context(), nil)
 	if err != nil {
-		log.Fatal(err)
+		abortWithError(c, err)
+		return
 	}
-	delete.Exec()
+	defer tx.Rollback()
+
+	delete, err := tx.PrepareContext(c.Request.Context(), "DELETE FROM infection")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if _, err := delete.ExecContext(c.Request.Context()); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	insert, err := tx.PrepareContext(c.Request.Context(), "INSERT INTO infection(date, name_jp, npatients) values (?,?,?)")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	defer insert.Close()
 
 	for _, v := range data.ItemList {
-		insert, err := db.Prepare("INSERT INTO infection(date, name_jp, npatients) values (?,?,?)")
-		if err != nil {
-			log.Fatal(err)
+		// タイムアウトやクライアントの切断で中断する
+		if _, err := insert.ExecContext(c.Request.Context(), v.Date, v.NameJp, v.Npatients); err != nil {
+			abortWithError(c, err)
+			return
 		}
-		insert.Exec(v.Date, v.NameJp, v.Npatients)
 	}
 
-	log.Print("データ取り込み完了")
+	if err := tx.Commit(); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	importRows.WithLabelValues(sourceInfections).Set(float64(len(data.ItemList)))
+	markModified(c.Request.Context(), db, sourceInfections)
+	c.Status(http.StatusOK)
+
+	requestLogger(c).Info("データ取り込み完了", "dataset", sourceInfections, "rows", len(data.ItemList))
 }
 
 func ImportMedical(c *gin.Context) {
-	log.Print("データ取り込み中")
+	requestLogger(c).Info("データ取り込み中", "dataset", sourceMedical)
+	defer observeImport(c, sourceMedical, time.Now())
+	phases := newImportTrace(c)
+	defer phases.End()
+
+	phases.Phase("fetch")
 	// JSONデータを取得する
-	resp, err := http.Get("https://opendata.corona.go.jp/api/covid19DailySurvey")
+	resp, err := fetch(c.Request.Context(), "https://opendata.corona.go.jp/api/covid19DailySurvey")
 	if err != nil {
-		panic(err)
+		abortWithError(c, errUpstream(err))
+		return
 	}
 	defer resp.Body.Close()
 
 	byteArray, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		panic(err)
+		abortWithError(c, errUpstream(err))
+		return
 	}
 
+	phases.Phase("decode")
 	var records []Medical
 	if err := json.Unmarshal(byteArray, &records); err != nil {
-		panic(err)
+		abortWithError(c, errUpstream(err))
+		return
 	}
 
-	db, err := sql.Open("mysql", "root:password@(localhost:3306)/local?parseTime=true")
+	phases.Phase("write")
+	db, err := database()
 	if err != nil {
-		panic(err)
+		abortWithError(c, err)
+		return
 	}
-	defer db.Close()
 
-	delete, err := db.Prepare("DELETE FROM medical")
+	// 途中で中断した場合に空のテーブルが残らないよう、入れ替えを1つのトランザクションで行う
+	tx, err := db.BeginTx(c.Request.Context(), nil)
 	if err != nil {
-		log.Fatal(err)
+		abortWithError(c, err)
+		return
 	}
-	delete.Exec()
+	defer tx.Rollback()
 
-	insert, err := db.Prepare("INSERT INTO medical (facility_id, facility_name, zip_code, pref_name, facility_addr, facility_tel, latitude, longitude, submit_date, facility_type, ans_type, local_gov_code, city_name, facility_code) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?)")
+	delete, err := tx.PrepareContext(c.Request.Context(), "DELETE FROM medical")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
+		return
+	}
+	if _, err := delete.ExecContext(c.Request.Context()); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	insert, err := tx.PrepareContext(c.Request.Context(), "INSERT INTO medical (facility_id, facility_name, zip_code, pref_name, facility_addr, facility_tel, latitude, longitude, submit_date, facility_type, ans_type, local_gov_code, city_name, facility_code) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?)")
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
 	defer insert.Close()
 
 	for _, f := range records {
-		_, err = insert.Exec(f.FacilityId, f.FacilityName, f.ZipCode, f.PrefName, f.FacilityAddr, f.FacilityTel, f.Latitude, f.Longitude, f.SubmitDate, f.FacilityType, f.AnsType, f.LocalGovCode, f.CityName, f.FacilityCode)
+		_, err = insert.ExecContext(c.Request.Context(), f.FacilityId, f.FacilityName, f.ZipCode, f.PrefName, f.FacilityAddr, f.FacilityTel, f.Latitude, f.Longitude, f.SubmitDate, f.FacilityType, f.AnsType, f.LocalGovCode, f.CityName, f.FacilityCode)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			abortWithError(c, err)
 			return
 		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	importRows.WithLabelValues(sourceMedical).Set(float64(len(records)))
+	markModified(c.Request.Context(), db, sourceMedical)
 	c.Status(http.StatusOK)
 
-	log.Print("データ取り込み完了")
+	requestLogger(c).Info("データ取り込み完了", "dataset", sourceMedical, "rows", len(records))
 }
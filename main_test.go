@@ -2,12 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -17,6 +19,16 @@ import (
 	"gopkg.in/go-playground/validator.v9"
 )
 
+// DB を使うテストのために起動時と同じくテーブルを作成・変更しておく (DB がなければそれらのテストが失敗する)
+func TestMain(m *testing.M) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := migrate(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "migration skipped:", err)
+	}
+	cancel()
+	os.Exit(m.Run())
+}
+
 func TestLoggingMiddleware(t *testing.T) {
 	req, _ := http.NewRequest("GET", "/", nil)
 	w := httptest.NewRecorder()
@@ -39,23 +51,16 @@ func TestLoggingMiddleware(t *testing.T) {
 }
 
 func TestCountOfPatient(t *testing.T) {
-	// Start the server in a goroutine
-	go main()
-
-	// Wait for the server to start
-	time.Sleep(1 * time.Second)
+	r := setupRouter()
 
 	// Send a fake HTTP request
-	res, err := http.Get("http://localhost:8080/count/2022-01-01")
-	if err != nil {
-		t.Error(err)
-		return
-	}
-	defer res.Body.Close()
+	req, _ := http.NewRequest("GET", "/count/2022-01-01", nil)
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, req)
 
 	// Check the HTTP status code
-	if res.StatusCode != http.StatusOK {
-		t.Errorf("unexpected HTTP status code: %d", res.StatusCode)
+	if res.Code != http.StatusOK {
+		t.Errorf("unexpected HTTP status code: %d", res.Code)
 	}
 
 	// Check the response body
@@ -63,7 +68,7 @@ func TestCountOfPatient(t *testing.T) {
 		Date      string `json:"date"`
 		Npatients int    `json:"npatients"`
 	}
-	err = json.NewDecoder(res.Body).Decode(&response)
+	err := json.NewDecoder(res.Body).Decode(&response)
 	if err != nil {
 		t.Error(err)
 		return
@@ -76,6 +81,23 @@ func TestCountOfPatient(t *testing.T) {
 	}
 }
 
+// データのない日は 500 ではなく 404 を返す
+func TestNoDataNotFound(t *testing.T) {
+	r := setupRouter()
+	for _, path := range []string{
+		"/api/v1/infections/1900-01-01/total",
+		"/api/v1/prefectures/01/recent/1900-01-01",
+		"/api/v1/prefectures/01/diffs/1900-01-01",
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		if res.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d %s", path, res.Code, res.Body.String())
+		}
+	}
+}
+
 func TestValidate(t *testing.T) {
 	validate := Validate()
 	if validate == nil {
@@ -101,8 +123,11 @@ func TestCreate(t *testing.T) {
 
 	r.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+	if !strings.HasPrefix(rr.Header().Get("Location"), "/api/v1/events/") {
+		t.Errorf("Location header is missing: %q", rr.Header().Get("Location"))
 	}
 }
 
@@ -202,8 +227,8 @@ func TestDelete(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if res.StatusCode != http.StatusOK {
-		t.Errorf("Expected status code %d, got %d", http.StatusOK, res.StatusCode)
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code %d or %d, got %d", http.StatusNoContent, http.StatusNotFound, res.StatusCode)
 	}
 }
 
@@ -250,10 +275,12 @@ func TestSecondSecond(t *testing.T) {
 	place := "Tokyo"
 	date := "2022-01"
 
-	res, _ := http.Get(fmt.Sprintf("http://localhost:8080/npatientsinmonth/%s/%s", place, date))
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/npatientsinmonth/%s/%s", place, date), nil)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
 
-	if res.StatusCode != http.StatusOK {
-		t.Errorf("Expected status OK, got %v", res.Status)
+	if res.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %v", res.Code)
 	}
 
 	body, _ := ioutil.ReadAll(res.Body)
@@ -276,15 +303,17 @@ func TestSecondSecond(t *testing.T) {
 
 func TestSecondThird(t *testing.T) {
 	router := gin.New()
-	router.GET("/npatientsinyear/:place/:date", SecondSecond)
+	router.GET("/npatientsinyear/:place/:date", SecondThird)
 
 	place := "Tokyo"
 	date := "2022"
 
-	res, _ := http.Get(fmt.Sprintf("http://localhost:8080/npatientsinyear/%s/%s", place, date))
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/npatientsinyear/%s/%s", place, date), nil)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
 
-	if res.StatusCode != http.StatusOK {
-		t.Errorf("Expected status OK, got %v", res.Status)
+	if res.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %v", res.Code)
 	}
 
 	body, _ := ioutil.ReadAll(res.Body)
@@ -312,10 +341,12 @@ func TestThirdSecond(t *testing.T) {
 	date1 := "2022-01-01"
 	date2 := "2022-01-31"
 
-	res, _ := http.Get(fmt.Sprintf("http://localhost:8080/getInfection/%s/%s", date1, date2))
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/getInfection/%s/%s", date1, date2), nil)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
 
-	if res.StatusCode != http.StatusOK {
-		t.Errorf("Expected status OK, got %v", res.Status)
+	if res.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %v", res.Code)
 	}
 
 	body, _ := ioutil.ReadAll(res.Body)
@@ -342,10 +373,12 @@ func TestThirdThird(t *testing.T) {
 	date1 := "2022-01-01"
 	date2 := "2022-01-31"
 
-	res, _ := http.Get(fmt.Sprintf("http://localhost:8080/getnpatients/%s/%s/%s", place, date1, date2))
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/getnpatients/%s/%s/%s", place, date1, date2), nil)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
 
-	if res.StatusCode != http.StatusOK {
-		t.Errorf("Expected status OK, got %v", res.Status)
+	if res.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %v", res.Code)
 	}
 
 	body, _ := ioutil.ReadAll(res.Body)
@@ -380,6 +413,7 @@ func TestForthFirst(t *testing.T) {
 
 	ctx, _ := gin.CreateTestContext(recorder)
 	ctx.Request = req
+	ctx.Params = gin.Params{{Key: "place", Value: "tokyo"}}
 
 	ForthFirst(ctx)
 
@@ -436,26 +470,21 @@ func TestFifthFirst(t *testing.T) {
 }
 
 func TestSecondFirst(t *testing.T) {
-	// Start the server
-	go main()
-	time.Sleep(1 * time.Second) // Wait for the server to start
+	r := setupRouter()
 
 	// Create a new HTTP request
-	req, err := http.NewRequest("GET", "http://localhost:8080/secondfirst/北海道/2022-01-01", nil)
+	req, err := http.NewRequest("GET", "/secondfirst/北海道/2022-01-01", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// Create a new HTTP client and execute the request
-	client := &http.Client{}
-	res, err := client.Do(req)
-	if err != nil {
-		t.Fatal(err)
-	}
+	// Execute the request
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, req)
 
 	// // Check the status code
-	// if res.StatusCode != http.StatusOK {
-	// 	t.Errorf("unexpected status code: %d", res.StatusCode)
+	// if res.Code != http.StatusOK {
+	// 	t.Errorf("unexpected status code: %d", res.Code)
 	// }
 
 	// Decode the response body
@@ -469,3 +498,41 @@ func TestSecondFirst(t *testing.T) {
 		t.Errorf("unexpected number of infections: %d", len(infections))
 	}
 }
+
+func TestDailyDiffs(t *testing.T) {
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	cumulative := map[string][]seriesPoint{
+		"北海道": testSeries(from, 100, 120, 130),
+		"青森県": testSeries(from, 50, 60),
+		"東京都": testSeries(from, 1000, 1100, 1300),
+	}
+
+	diffs, err := dailyDiffs([]string{"北海道", "青森県", "東京都"}, cumulative)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []dailyDiff{{"北海道", 10, 20}, {"東京都", 200, 100}}
+	assert.Equal(t, expected, diffs)
+
+	if _, err := dailyDiffs([]string{"青森県"}, cumulative); err == nil {
+		t.Error("expected not found error")
+	}
+}
+
+func TestRiskMessage(t *testing.T) {
+	tests := map[float64]string{150: "Too Danger", 140: "Danger", 121: "Danger", 110: "Warning", 90: "Caution", 80: "attention", 0: "attention"}
+	for culc, expected := range tests {
+		assert.Equal(t, expected, riskMessage(culc), "culc %v", culc)
+	}
+}
+
+func TestAreaRisk(t *testing.T) {
+	m := areaRisk(Medical_count{Place: "東京都", HospitalCount: 10, Npatients: 20000})
+	assert.Equal(t, "1140", m.Per)
+	assert.Equal(t, "Too Danger Area", m.Message)
+
+	// 病院がない場合
+	m = areaRisk(Medical_count{Place: "東京都", Npatients: 20000})
+	assert.Equal(t, "0", m.Per)
+	assert.Equal(t, "attention Area", m.Message)
+}
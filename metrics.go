@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// クエリの所要時間と span を記録する MySQL ドライバー (接続プールはこのドライバーで開く)
+const dbDriverName = "mysql+metrics"
+
+func init() {
+	sql.Register(dbDriverName, instrumentedDriver{&mysql.MySQLDriver{}})
+}
+
+// -------------
+// Prometheus のメトリクス
+// -------------
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "corona_http_requests_total",
+		Help: "HTTP requests by route template, method and status.",
+	}, []string{"route", "method", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "corona_http_request_duration_seconds",
+		Help:    "HTTP request latency by route template and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "corona_db_query_duration_seconds",
+		Help:    "Time until MySQL returned the first result, by statement and table.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"operation", "table"})
+
+	importDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "corona_import_duration_seconds",
+		Help:    "Duration of open data imports.",
+		Buckets: []float64{1, 5, 10, 30, 60, 120, 300},
+	}, []string{"dataset"})
+	importRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "corona_imports_total",
+		Help: "Open data imports by result (success or failure).",
+	}, []string{"dataset", "result"})
+	importRows = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "corona_import_rows",
+		Help: "Rows written by the last successful import.",
+	}, []string{"dataset"})
+)
+
+func init() {
+	cacheCounter := func(name, help string, value func(cacheStats) uint64) {
+		promauto.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help, ConstLabels: prometheus.Labels{"cache": "analytics"}},
+			func() float64 { return float64(value(analyticsCache.Stats())) })
+	}
+	cacheCounter("corona_cache_hits_total", "Result cache hits.", func(s cacheStats) uint64 { return s.Hits })
+	cacheCounter("corona_cache_misses_total", "Result cache misses.", func(s cacheStats) uint64 { return s.Misses })
+	cacheCounter("corona_cache_evictions_total", "Result cache entries dropped because the cache was full.", func(s cacheStats) uint64 { return s.Evictions })
+	cacheCounter("corona_cache_invalidations_total", "Result cache entries dropped by imports.", func(s cacheStats) uint64 { return s.Invalidations })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{Name: "corona_cache_entries", Help: "Result cache entries.", ConstLabels: prometheus.Labels{"cache": "analytics"}},
+		func() float64 { return float64(analyticsCache.Stats().Entries) })
+
+	// データの鮮度 (time() - この値 で経過時間を監視する)
+	for _, source := range []string{sourceInfections, sourceMedical, sourceEvents} {
+		source := source
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "corona_data_last_modified_timestamp_seconds",
+			Help:        "Unix time of the last import or update of each dataset (0 if never recorded).",
+			ConstLabels: prometheus.Labels{"dataset": source},
+		}, func() float64 {
+			t := lastModified(source)
+			if t.IsZero() {
+				return 0
+			}
+			return float64(t.Unix())
+		})
+	}
+}
+
+// リクエスト数と所要時間をルート (gin のパス) ごとに記録する
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched" // 存在しない URL でラベルが増えないようにまとめる
+		}
+		httpRequests.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+	}
+}
+
+func Metrics() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// 取り込みの最後に defer で呼ぶ。中断 (エラー) した場合は failure として数える
+func observeImport(c *gin.Context, dataset string, start time.Time) {
+	result := "success"
+	if c.IsAborted() {
+		result = "failure"
+	}
+	importRuns.WithLabelValues(dataset, result).Inc()
+	importDuration.WithLabelValues(dataset).Observe(time.Since(start).Seconds())
+}
+
+var queryTable = regexp.MustCompile("(?i)\\b(?:from|into|update|table(?: if not exists)?)\\s+`?(\\w+)")
+
+// SQL の種類とテーブル (ラベルの種類が増えすぎないよう SQL 全体は使わない)
+func queryLabels(query string) (string, string) {
+	operation := "other"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		operation = strings.ToLower(fields[0])
+	}
+	table := "none"
+	if m := queryTable.FindStringSubmatch(query); m != nil {
+		table = strings.ToLower(m[1])
+	}
+	return operation, table
+}
+
+func observeQuery(query string, start time.Time) {
+	operation, table := queryLabels(query)
+	dbQueryDuration.WithLabelValues(operation, table).Observe(time.Since(start).Seconds())
+}
+
+// -------------
+// クエリの所要時間と span を記録するドライバー
+// -------------
+
+type instrumentedDriver struct {
+	driver.Driver
+}
+
+func (d instrumentedDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{conn}, nil
+}
+
+// mysql の接続のうち database/sql が使うインターフェースをそのまま呼び出す
+type instrumentedConn struct {
+	driver.Conn
+}
+
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (rows driver.Rows, err error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, span := startQuerySpan(ctx, query)
+	defer endQuery(span, query, time.Now(), &err)
+	return q.QueryContext(ctx, query, args)
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (result driver.Result, err error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, span := startQuerySpan(ctx, query)
+	defer endQuery(span, query, time.Now(), &err)
+	return e.ExecContext(ctx, query, args)
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedStmt{stmt, query}, nil
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *instrumentedConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *instrumentedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type instrumentedStmt struct {
+	driver.Stmt
+	query string
+}
+
+func (s *instrumentedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (result driver.Result, err error) {
+	ctx, span := startQuerySpan(ctx, s.query)
+	defer endQuery(span, s.query, time.Now(), &err)
+	return s.Stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+}
+
+func (s *instrumentedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
+	ctx, span := startQuerySpan(ctx, s.query)
+	defer endQuery(span, s.query, time.Now(), &err)
+	return s.Stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestQueryLabels(t *testing.T) {
+	tests := []struct {
+		query, operation, table string
+	}{
+		{"select date, name_jp, npatients from infection where date between ? and ?", "select", "infection"},
+		{"SELECT title, description, begin, `end` FROM events", "select", "events"},
+		{"INSERT INTO infection(date, name_jp, npatients) values (?,?,?)", "insert", "infection"},
+		{"UPDATE events SET title = ? WHERE id = ?", "update", "events"},
+		{"DELETE FROM medical", "delete", "medical"},
+		{"create table if not exists last_modified (name varchar(32) primary key)", "create", "last_modified"},
+		{"select i.name_jp, count(m.facility_id) from infection i left join medical m on m.pref_name = i.name_jp", "select", "infection"},
+		{"SELECT 1", "select", "none"},
+	}
+	for _, tt := range tests {
+		operation, table := queryLabels(tt.query)
+		if operation != tt.operation || table != tt.table {
+			t.Errorf("queryLabels(%q) = %s, %s", tt.query, operation, table)
+		}
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	r := setupRouter()
+	before := testutil.ToFloat64(httpRequests.WithLabelValues("/api/v1/prefectures", "GET", "200"))
+	unmatched := testutil.ToFloat64(httpRequests.WithLabelValues("unmatched", "GET", "404"))
+
+	for _, path := range []string{"/api/v1/prefectures", "/api/v1/prefectures", "/no/such/path"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues("/api/v1/prefectures", "GET", "200")) - before; got != 2 {
+		t.Errorf("expected 2 requests, got %v", got)
+	}
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues("unmatched", "GET", "404")) - unmatched; got != 1 {
+		t.Errorf("expected 1 unmatched request, got %v", got)
+	}
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	for _, name := range []string{
+		`corona_http_requests_total{method="GET",route="/api/v1/prefectures",status="200"}`,
+		"corona_http_request_duration_seconds_bucket",
+		`corona_cache_hits_total{cache="analytics"}`,
+		`corona_data_last_modified_timestamp_seconds{dataset="infections"}`,
+	} {
+		if !strings.Contains(w.Body.String(), name) {
+			t.Errorf("/metrics does not contain %s", name)
+		}
+	}
+}
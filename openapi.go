@@ -0,0 +1,490 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// -------------
+// OpenAPI 3 仕様
+// -------------
+
+type paramDoc struct {
+	Name        string
+	In          string // path / query
+	Type        string // string / integer / boolean
+	Format      string // date など
+	Enum        []string
+	Required    bool
+	Description string
+}
+
+type routeDoc struct {
+	Method      string
+	Path        string // gin の形式 (:param)
+	OperationId string
+	Summary     string
+	Tag         string
+	Params      []paramDoc
+	Body        interface{} // リクエストボディの型
+	Response    interface{} // 200 のレスポンスの型 (nil ならボディなし)
+	Images      bool        // PNG/SVG を返す
+	Export      bool        // ?format= で CSV/XLSX も返す
+	Page        *sortSpec   // limit, cursor, sort, fields に対応する
+	Role        role        // 必要な権限 (0 なら AUTH_REQUIRE_READ=true のときのみ reader)
+	Status      int         // 成功時のステータス (0 なら 200)
+	Included    interface{} // ?include=events のときのレスポンスの型 (nil なら include に対応しない)
+}
+
+// 旧URL と /api/v1 の対応 (Rename は v1 のパラメータ名 → 旧URL のパラメータ名)
+type legacyDoc struct {
+	Method string
+	Path   string
+	V1Path string
+	Rename map[string]string
+}
+
+type patientsTotal struct {
+	Date      time.Time `json:"date"`
+	Npatients int       `json:"npatients"`
+}
+
+type cacheStatsResponse struct {
+	Analytics cacheStats `json:"analytics"` // 危険度などの集計結果のキャッシュ
+}
+
+type rateLimitUsageResponse struct {
+	Enabled bool          `json:"enabled"` // RATE_LIMIT_RPS=0 なら false (rate, burst は返さない)
+	Rate    float64       `json:"rate"`
+	Burst   float64       `json:"burst"`
+	Clients []clientUsage `json:"clients"` // 使ったトークンの多い順
+}
+
+type infectionsWithEvents struct {
+	Infections []infection `json:"infections"`
+	Events     []Event     `json:"events"` // 都道府県 (または全国) が対象で期間と重なるメモ
+}
+
+func pathParam(name, description string) paramDoc {
+	return paramDoc{Name: name, In: "path", Type: "string", Required: true, Description: description}
+}
+
+func dateParam(name, in, description string) paramDoc {
+	return paramDoc{Name: name, In: in, Type: "string", Format: "date", Required: true, Description: description}
+}
+
+func queryParam(name, description string) paramDoc {
+	return paramDoc{Name: name, In: "query", Type: "string", Description: description}
+}
+
+func enumParam(name, description string, enum []string) paramDoc {
+	return paramDoc{Name: name, In: "query", Type: "string", Enum: enum, Description: description}
+}
+
+var codeParam = pathParam("code", "都道府県コード (01-47)・都道府県名・ローマ字表記")
+
+// メモの一覧の絞り込み (eventConditions)
+var eventFilterParams = []paramDoc{
+	queryParam("q", "タイトルか説明に含む語 (空白区切りですべてを含む)"),
+	{Name: "from", In: "query", Type: "string", Format: "date", Description: "この日以降に終わるメモ (to と合わせて期間が重なるメモ)"},
+	{Name: "to", In: "query", Type: "string", Format: "date", Description: "この日以前に始まるメモ"},
+	{Name: "active_on", In: "query", Type: "string", Format: "date", Description: "この日に期間中のメモ"},
+	queryParam("tag", "このタグが付いたメモ (複数指定した場合はすべてが付いたメモ)"),
+}
+
+var v1Docs = []routeDoc{
+	{Method: "GET", Path: "/api/v1/infections", OperationId: "listInfections", Tag: "infections", Summary: "期間内の47都道府県の累計感染者数",
+		Params: []paramDoc{dateParam("from", "query", "開始日"), dateParam("to", "query", "終了日 (開始日から366日以内)")}, Response: []infection{}, Export: true, Page: &infectionSorts},
+	{Method: "GET", Path: "/api/v1/infections/:date/total", OperationId: "getInfectionTotal", Tag: "infections", Summary: "日の累計感染者数の全国合計",
+		Params: []paramDoc{dateParam("date", "path", "日付")}, Response: patientsTotal{}},
+	{Method: "GET", Path: "/api/v1/aggregate", OperationId: "aggregateInfections", Tag: "infections", Summary: "日・週・ISO週・月・四半期・年ごとの集計",
+		Params: []paramDoc{
+			queryParam("places", "カンマ区切りの都道府県 (省略時は47都道府県)"),
+			dateParam("from", "query", "開始日"),
+			dateParam("to", "query", "終了日"),
+			enumParam("bucket", "集計単位 (既定値 week)", aggregateBuckets),
+			enumParam("func", "sum: 新規感染者数の合計, last: 期間末の累計, mean: 新規感染者数の平均, max: 新規感染者数の最大 (既定値 sum)", aggregateFuncs),
+			{Name: "combine", In: "query", Type: "boolean", Description: "true の場合は都道府県を合算する"},
+		}, Response: []aggregation{}},
+	{Method: "GET", Path: "/api/v1/risk/:date", OperationId: "getRisk", Tag: "risk", Summary: "前日比・前々日比による都道府県の危険度",
+		Params: []paramDoc{dateParam("date", "path", "日付")}, Response: []diff_Npatients_Place{}},
+	{Method: "GET", Path: "/api/v1/risk/:date/rates", OperationId: "getRiskRates", Tag: "risk", Summary: "前日比・前々日比による都道府県の危険度 (増加率つき)",
+		Params: []paramDoc{dateParam("date", "path", "日付")}, Response: []diff_Npatients_Place_Per{}},
+	{Method: "GET", Path: "/api/v1/risk/:date/areas", OperationId: "getRiskAreas", Tag: "risk", Summary: "病院数と感染者数による都道府県の危険度",
+		Params: []paramDoc{dateParam("date", "path", "日付")}, Response: []Medical_count{}},
+	{Method: "GET", Path: "/api/v1/prefectures", OperationId: "listPrefectures", Tag: "prefectures", Summary: "47都道府県の一覧",
+		Response: []prefecture{}},
+	{Method: "GET", Path: "/api/v1/prefectures/:code/series", OperationId: "getPrefectureSeries", Tag: "prefectures", Summary: "期間内の累計感染者数",
+		Params: []paramDoc{codeParam, dateParam("from", "query", "開始日"), dateParam("to", "query", "終了日")}, Response: []infection{}, Export: true, Page: &infectionSorts, Included: infectionsWithEvents{}},
+	{Method: "GET", Path: "/api/v1/prefectures/:code/recent/:date", OperationId: "getPrefectureRecent", Tag: "prefectures", Summary: "指定日より前の7日間の累計感染者数",
+		Params: []paramDoc{codeParam, dateParam("date", "path", "日付")}, Response: []infection{}, Included: infectionsWithEvents{}},
+	{Method: "GET", Path: "/api/v1/prefectures/:code/diffs/:date", OperationId: "getPrefectureDiffs", Tag: "prefectures", Summary: "指定日から6日間の前日比",
+		Params: []paramDoc{codeParam, dateParam("date", "path", "日付")}, Response: []diff_Npatients{}},
+	{Method: "GET", Path: "/api/v1/prefectures/:code/months/:month", OperationId: "getPrefectureMonth", Tag: "prefectures", Summary: "月の累計感染者数",
+		Params: []paramDoc{codeParam, pathParam("month", "年月 (YYYY-MM)")}, Response: []infection{}},
+	{Method: "GET", Path: "/api/v1/prefectures/:code/years/:year", OperationId: "getPrefectureYear", Tag: "prefectures", Summary: "年の累計感染者数",
+		Params: []paramDoc{codeParam, pathParam("year", "年 (YYYY)")}, Response: []infection{}},
+	{Method: "GET", Path: "/api/v1/prefectures/:code/chart", OperationId: "getPrefectureChart", Tag: "prefectures", Summary: "感染者数のグラフ",
+		Params: []paramDoc{
+			codeParam,
+			{Name: "from", In: "query", Type: "string", Format: "date", Description: "開始日 (省略時は終了日の90日前)"},
+			{Name: "to", In: "query", Type: "string", Format: "date", Description: "終了日 (省略時は最新日)"},
+			enumParam("metric", "daily: 新規感染者数, cumulative: 累計 (既定値 daily)", []string{"daily", "cumulative"}),
+			enumParam("type", "グラフの種類 (既定値 line)", []string{"line", "bar"}),
+			enumParam("format", "画像形式 (既定値 png)", []string{"png", "svg"}),
+			{Name: "ma", In: "query", Type: "integer", Description: "移動平均の日数"},
+			{Name: "events", In: "query", Type: "boolean", Description: "メモを重ねて表示する (既定値 true)"},
+		}, Images: true},
+	{Method: "GET", Path: "/api/v1/prefectures/:code/facilities", OperationId: "listPrefectureFacilities", Tag: "facilities", Summary: "都道府県内の医療機関",
+		Params: []paramDoc{codeParam}, Response: []Medicals{}, Export: true, Page: &medicalSorts},
+	{Method: "GET", Path: "/api/v1/prefectures/:code/events", OperationId: "listPrefectureEvents", Tag: "events", Summary: "都道府県 (または全国) が対象のコロナに関するメモの一覧",
+		Params: append([]paramDoc{codeParam}, eventFilterParams...), Response: []Event{}, Export: true, Page: &eventSorts},
+	{Method: "GET", Path: "/api/v1/facilities", OperationId: "searchFacilities", Tag: "facilities", Summary: "住所と状況で医療機関を検索",
+		Params: []paramDoc{queryParam("place", "住所の前方一致 (例: 札幌市)"), queryParam("status", "状況 (例: 入院)")}, Response: []Medicals_show{}, Export: true, Page: &hospitalSorts},
+	{Method: "GET", Path: "/api/v1/facilities/:name", OperationId: "getFacility", Tag: "facilities", Summary: "医療機関の詳細",
+		Params: []paramDoc{pathParam("name", "医療機関名")}, Response: Medicals_show{}},
+	{Method: "POST", Path: "/api/v1/events", OperationId: "createEvent", Tag: "events", Summary: "コロナに関するメモを追加",
+		Body: Event_JSON{}, Response: Event{}, Role: roleEditor, Status: http.StatusCreated},
+	{Method: "GET", Path: "/api/v1/events", OperationId: "listEvents", Tag: "events", Summary: "コロナに関するメモの一覧",
+		Params:   append([]paramDoc{queryParam("prefecture", "この都道府県 (コード・名前・ローマ字) または全国が対象のメモ")}, eventFilterParams...),
+		Response: []Event{}, Export: true, Page: &eventSorts},
+	{Method: "GET", Path: "/api/v1/events/:id", OperationId: "getEvent", Tag: "events", Summary: "コロナに関するメモを表示",
+		Params: []paramDoc{{Name: "id", In: "path", Type: "integer", Required: true}}, Response: Event{}},
+	{Method: "PATCH", Path: "/api/v1/events/:id", OperationId: "updateEvent", Tag: "events", Summary: "コロナに関するメモを変更",
+		Params: []paramDoc{{Name: "id", In: "path", Type: "integer", Required: true}}, Body: eventPatch{}, Response: Event{}, Role: roleEditor},
+	{Method: "DELETE", Path: "/api/v1/events/:id", OperationId: "deleteEvent", Tag: "events", Summary: "コロナに関するメモを削除",
+		Params: []paramDoc{{Name: "id", In: "path", Type: "integer", Required: true}}, Role: roleEditor, Status: http.StatusNoContent},
+	{Method: "GET", Path: "/api/v1/events/:id/impact", OperationId: "getEventImpact", Tag: "events", Summary: "メモの開始日・終了日の前後で新規感染者数の傾向 (増加率・実効再生産数・分割時系列回帰) を比べる",
+		Params: []paramDoc{
+			{Name: "id", In: "path", Type: "integer", Required: true},
+			{Name: "window", In: "query", Type: "integer", Description: "前後それぞれの日数 (7-90、既定値 28)"},
+		}, Response: eventImpact{}},
+	{Method: "POST", Path: "/api/v1/imports/infections", OperationId: "importInfections", Tag: "imports", Summary: "都道府県の感染者数をオープンデータから取り込む", Role: roleAdmin},
+	{Method: "POST", Path: "/api/v1/imports/medical", OperationId: "importMedical", Tag: "imports", Summary: "医療機関の状況をオープンデータから取り込む", Role: roleAdmin},
+}
+
+// 運用のルート (JSON を返すもの)
+var operationsDocs = []routeDoc{
+	{Method: "GET", Path: "/status", OperationId: "getStatus", Tag: "operations", Summary: "最新の感染者数の日付、データごとの最終取り込み日時と件数",
+		Response: serviceStatus{}},
+	{Method: "GET", Path: "/debug/cache", OperationId: "getCacheStats", Tag: "operations", Summary: "集計結果のキャッシュのヒット・ミス数",
+		Response: cacheStatsResponse{}, Role: roleAdmin},
+	{Method: "GET", Path: "/admin/usage", OperationId: "getRateLimitUsage", Tag: "operations", Summary: "クライアントごとのリクエスト数・429 の回数",
+		Response: rateLimitUsageResponse{}, Role: roleAdmin},
+}
+
+var legacyDocs = []legacyDoc{
+	{"GET", "/count/:date", "/api/v1/infections/:date/total", nil},
+	{"GET", "/firstfirst/:date", "/api/v1/risk/:date", nil},
+	{"GET", "/firstsecond/:date", "/api/v1/risk/:date/rates", nil},
+	{"GET", "/secondfirst/:place/:date", "/api/v1/prefectures/:code/recent/:date", map[string]string{"code": "place"}},
+	{"GET", "/diffadd/:place/:date", "/api/v1/prefectures/:code/diffs/:date", map[string]string{"code": "place"}},
+	{"GET", "/npatientsinmonth/:place/:date", "/api/v1/prefectures/:code/months/:month", map[string]string{"code": "place", "month": "date"}},
+	{"GET", "/npatientsinyear/:place/:date", "/api/v1/prefectures/:code/years/:year", map[string]string{"code": "place", "year": "date"}},
+	{"POST", "/create", "/api/v1/events", nil},
+	{"GET", "/show/:id", "/api/v1/events/:id", nil},
+	{"GET", "/shows", "/api/v1/events", nil},
+	{"PATCH", "/show/:id", "/api/v1/events/:id", nil},
+	{"DELETE", "/delete/:id", "/api/v1/events/:id", nil},
+	{"GET", "/getInfection/:date1/:date2", "/api/v1/infections", map[string]string{"from": "date1", "to": "date2"}},
+	{"GET", "/getnpatients/:place/:date1/:date2", "/api/v1/prefectures/:code/series", map[string]string{"code": "place", "from": "date1", "to": "date2"}},
+	{"GET", "/medicals/:place", "/api/v1/prefectures/:code/facilities", map[string]string{"code": "place"}},
+	{"GET", "/medical/:hospital_name", "/api/v1/facilities/:name", map[string]string{"name": "hospital_name"}},
+	{"GET", "/hospital/:place/:status", "/api/v1/facilities", nil},
+	{"GET", "/safearea/:date", "/api/v1/risk/:date/areas", nil},
+	{"GET", "/chart/:place", "/api/v1/prefectures/:code/chart", map[string]string{"code": "place"}},
+	{"POST", "/import", "/api/v1/imports/infections", nil},
+	{"POST", "/importmedical", "/api/v1/imports/medical", nil},
+}
+
+// 旧URL の routeDoc を v1 の routeDoc から作る
+func legacyRouteDocs() []routeDoc {
+	var docs []routeDoc
+	for _, l := range legacyDocs {
+		for _, d := range v1Docs {
+			if d.Method != l.Method || d.Path != l.V1Path {
+				continue
+			}
+			legacy := d
+			legacy.Path = l.Path
+			legacy.OperationId = d.OperationId + "Legacy"
+			legacy.Params = nil
+			for _, p := range d.Params {
+				if name, ok := l.Rename[p.Name]; ok {
+					p.Name = name
+				}
+				// 旧URL ではクエリパラメータだったものがパスに含まれることがある
+				if strings.Contains(l.Path+"/", ":"+p.Name+"/") {
+					p.In = "path"
+					p.Required = true
+				}
+				legacy.Params = append(legacy.Params, p)
+			}
+			docs = append(docs, legacy)
+		}
+	}
+	return docs
+}
+
+// gin のパス (:param) を OpenAPI のパス ({param}) に変換する
+func openapiPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") {
+			parts[i] = "{" + p[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// Go の型から JSON Schema を作る。名前のある構造体は components に登録して参照する
+func schemaOf(t reflect.Type, components map[string]interface{}) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), components)
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), components)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), components)}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Struct:
+		if t.Name() != "" {
+			if _, ok := components[t.Name()]; !ok {
+				components[t.Name()] = nil // 再帰する型のための予約
+				components[t.Name()] = structSchema(t, components)
+			}
+			return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		}
+		return structSchema(t, components)
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func structSchema(t reflect.Type, components map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.PkgPath != "" || name == "-" || name == "" {
+			continue
+		}
+		properties[name] = schemaOf(f.Type, components)
+		if strings.Contains(f.Tag.Get("validate"), "required") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func operation(d routeDoc, deprecated bool, components map[string]interface{}) map[string]interface{} {
+	op := map[string]interface{}{
+		"operationId": d.OperationId,
+		"summary":     d.Summary,
+		"tags":        []string{d.Tag},
+	}
+	if deprecated {
+		op["deprecated"] = true
+	}
+
+	docs := append([]paramDoc{}, d.Params...)
+	if d.Export {
+		docs = append(docs, enumParam("format", "出力形式 (省略時は Accept ヘッダー、既定値 json)", exportFormats))
+	}
+	if d.Included != nil {
+		docs = append(docs, enumParam("include", "events: 期間と重なるメモを含めたオブジェクトを返す (JSON のみ)", []string{"events"}))
+	}
+	if d.Page != nil {
+		docs = append(docs,
+			paramDoc{Name: "limit", In: "query", Type: "integer", Description: "1ページの件数 (1-1000、省略時は 100)。続きは Link ヘッダーの rel=\"next\" の URL で取得する"},
+			queryParam("cursor", "前のページの Link ヘッダーに含まれるカーソル"),
+			enumParam("sort", "並び順 (- を付けると降順)", d.Page.names()),
+			queryParam("fields", "カンマ区切りの返す項目 (JSON のみ)"),
+		)
+	}
+
+	var params []interface{}
+	for _, p := range docs {
+		schema := map[string]interface{}{"type": p.Type}
+		if p.Format != "" {
+			schema["format"] = p.Format
+		}
+		if len(p.Enum) > 0 {
+			schema["enum"] = p.Enum
+		}
+		param := map[string]interface{}{"name": p.Name, "in": p.In, "required": p.Required, "schema": schema}
+		if p.Description != "" {
+			param["description"] = p.Description
+		}
+		params = append(params, param)
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if d.Body != nil {
+		schema := map[string]interface{}{"schema": schemaOf(reflect.TypeOf(d.Body), components)}
+		content := map[string]interface{}{"application/json": schema}
+		if d.Method == http.MethodPatch {
+			content["application/merge-patch+json"] = schema // 指定した項目のみ変更する
+		}
+		op["requestBody"] = map[string]interface{}{"required": true, "content": content}
+	}
+
+	ok := map[string]interface{}{"description": "OK"}
+	switch {
+	case d.Images:
+		ok["content"] = map[string]interface{}{
+			"image/png":     map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}},
+			"image/svg+xml": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+		}
+	case d.Response != nil:
+		schema := schemaOf(reflect.TypeOf(d.Response), components)
+		if d.Included != nil {
+			schema = map[string]interface{}{"oneOf": []interface{}{schema, schemaOf(reflect.TypeOf(d.Included), components)}}
+		}
+		content := map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+		if d.Export {
+			content["text/csv"] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+			content[contentTypeXLSX] = map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}}
+		}
+		ok["content"] = content
+	}
+	status := http.StatusOK
+	if d.Status != 0 {
+		status = d.Status
+	}
+	if status == http.StatusCreated {
+		ok["description"] = "Created"
+		ok["headers"] = map[string]interface{}{
+			"Location": map[string]interface{}{"description": "作成したリソースの URL", "schema": map[string]interface{}{"type": "string"}},
+		}
+	}
+	if status == http.StatusNoContent {
+		ok["description"] = "No Content"
+	}
+	responses := map[string]interface{}{
+		strconv.Itoa(status): ok,
+		"400":                map[string]interface{}{"$ref": "#/components/responses/Error"},
+		"401":                map[string]interface{}{"$ref": "#/components/responses/Error"},
+		"404":                map[string]interface{}{"$ref": "#/components/responses/Error"},
+		"429":                map[string]interface{}{"$ref": "#/components/responses/Error"},
+		"500":                map[string]interface{}{"$ref": "#/components/responses/Error"},
+		"504":                map[string]interface{}{"$ref": "#/components/responses/Error"},
+	}
+	if d.Role != 0 {
+		op["description"] = d.Role.String() + " 以上の権限が必要"
+		op["security"] = []interface{}{
+			map[string]interface{}{"apiKey": []string{}},
+			map[string]interface{}{"bearerAuth": []string{}},
+		}
+		responses["403"] = map[string]interface{}{"$ref": "#/components/responses/Error"}
+	}
+	op["responses"] = responses
+	return op
+}
+
+func openapiSpec() map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]map[string]interface{}{}
+
+	add := func(d routeDoc, deprecated bool) {
+		path := openapiPath(d.Path)
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(d.Method)] = operation(d, deprecated, schemas)
+	}
+	for _, d := range v1Docs {
+		add(d, false)
+	}
+	for _, d := range operationsDocs {
+		add(d, false)
+	}
+	for _, d := range legacyRouteDocs() {
+		add(d, true)
+	}
+
+	schemas["Error"] = map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"error": schemaOf(reflect.TypeOf(apiError{}), schemas)},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "corona API",
+			"version":     "1.0.0",
+			"description": "国内のコロナ感染者の危険地帯がわかるAPI",
+		},
+		"paths": paths,
+		// 参照は AUTH_REQUIRE_READ=true のときのみ認証が必要
+		"security": []interface{}{
+			map[string]interface{}{},
+			map[string]interface{}{"apiKey": []string{}},
+			map[string]interface{}{"bearerAuth": []string{}},
+		},
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey":     map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "エラー",
+					"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"}}},
+				},
+			},
+		},
+	}
+}
+
+func OpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, openapiSpec())
+}
+
+// Swagger UI の初期化 (CSP で許可するためハッシュを計算する)
+const swaggerUIScript = `
+    window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+  `
+
+// Swagger UI (CDN から読み込む)
+const swaggerUIHTML = `<!DOCTYPE html>
+<html lang="ja">
+<head>
+  <meta charset="utf-8">
+  <title>corona API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>` + swaggerUIScript + `</script>
+</body>
+</html>
+`
+
+func SwaggerUI(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIHTML))
+}
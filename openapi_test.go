@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// API ではないルート
+var undocumentedRoutes = map[string]bool{
+	"GET /":                     true,
+	"GET /dashboard/*filepath":  true,
+	"HEAD /dashboard/*filepath": true,
+	"GET /openapi.json":         true,
+	"GET /docs":                 true,
+	"GET /metrics":              true,
+	"GET /healthz":              true,
+	"GET /readyz":               true,
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	r := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var spec struct {
+		Paths map[string]map[string]struct {
+			Parameters []struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, route := range r.Routes() {
+		if undocumentedRoutes[route.Method+" "+route.Path] {
+			continue
+		}
+		op, ok := spec.Paths[openapiPath(route.Path)][strings.ToLower(route.Method)]
+		if !ok {
+			t.Errorf("%s %s is not documented in openapi.json", route.Method, route.Path)
+			continue
+		}
+
+		// パスパラメータがすべて記述されていること
+		for _, part := range strings.Split(route.Path, "/") {
+			if !strings.HasPrefix(part, ":") {
+				continue
+			}
+			found := false
+			for _, p := range op.Parameters {
+				if p.Name == part[1:] && p.In == "path" {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("%s %s: path parameter %s is not documented", route.Method, route.Path, part)
+			}
+		}
+	}
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	spec := openapiSpec()
+	schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+	for _, name := range []string{"infection", "diff_Npatients_Place", "diff_Npatients_Place_Per", "Medicals_show", "Medical_count", "Event_JSON", "aggregation", "Error"} {
+		if _, ok := schemas[name]; !ok {
+			t.Errorf("schema %s is missing", name)
+		}
+	}
+
+	event := schemas["Event_JSON"].(map[string]interface{})
+	if required := event["required"].([]string); len(required) != 3 {
+		t.Errorf("Expected 3 required fields in Event_JSON, got %v", required)
+	}
+
+	// データごとの状況はデータ名をキーにしたオブジェクト
+	status := schemas["serviceStatus"].(map[string]interface{})
+	datasets := status["properties"].(map[string]interface{})["datasets"].(map[string]interface{})
+	if datasets["type"] != "object" || datasets["additionalProperties"] == nil {
+		t.Errorf("unexpected datasets schema %v", datasets)
+	}
+}
+
+func TestSwaggerUI(t *testing.T) {
+	r := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/docs", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/openapi.json") {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+}
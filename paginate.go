@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// limit の上限と、limit を省略した場合の件数
+const (
+	maxPageLimit     = 1000
+	defaultPageLimit = 100
+)
+
+// -------------
+// カーソルによるページング・並び替え・フィールドの絞り込み
+// -------------
+
+// 一覧で並び替えに使える列
+type sortSpec struct {
+	Columns map[string]string // ?sort= に指定できる名前 (json 名) → 列
+	Default string            // 省略時の並び順 (空なら Key の順)
+	Key     []string          // 行を一意にする列 (並び順の最後に付ける)
+}
+
+var infectionSorts = sortSpec{
+	Columns: map[string]string{"date": "date", "name_jp": "name_jp", "npatients": "npatients"},
+	Default: "date",
+	Key:     []string{"date", "name_jp"},
+}
+
+var eventSorts = sortSpec{
+	Columns: map[string]string{"id": "id", "title": "title", "begin": "begin", "end": "`end`", "created_at": "created_at", "updated_at": "updated_at"},
+	Key:     []string{"id"},
+}
+
+var medicalSorts = sortSpec{
+	Columns: map[string]string{"facilityName": "facility_name", "facilityAddr": "facility_addr", "facilityType": "facility_type"},
+	Key:     []string{"facility_id"},
+}
+
+var hospitalSorts = sortSpec{
+	Columns: map[string]string{
+		"facilityName": "facility_name", "zipCode": "zip_code", "cityName": "city_name", "facilityAddr": "facility_addr",
+		"submitDate": "submit_date", "facilityType": "facility_type",
+	},
+	Key: []string{"facility_id"},
+}
+
+// ?sort= に指定できる値 (- を付けると降順)
+func (s sortSpec) names() []string {
+	var names []string
+	for name := range s.Columns {
+		names = append(names, name, "-"+name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// カーソルの中身 (base64url の JSON)
+type cursor struct {
+	Sort  string        `json:"sort"`
+	After []interface{} `json:"after"`
+}
+
+// 1ページ分の取得条件。Scan で行を読み、Link で次のページの URL を返す
+type pager struct {
+	limit   int // 0 なら全件 (CSV/XLSX の出力)
+	sort    string
+	columns []string // 並び替えの列 + Key
+	desc    bool
+	after   []interface{}
+	fields  []string
+
+	last  []interface{} // 最後に返した行の並び替えの列の値
+	count int
+	more  bool
+}
+
+// limit, cursor, sort, fields を読み取る。row は1行分の構造体
+// paginate が false の場合 (CSV/XLSX の出力) は limit, cursor を無視して全件を返す
+func newPager(c *gin.Context, spec sortSpec, row interface{}, paginate bool) (*pager, error) {
+	p := &pager{sort: c.DefaultQuery("sort", spec.Default)}
+
+	if p.sort != "" {
+		name, err := parseEnum("sort", p.sort, spec.names())
+		if err != nil {
+			return nil, err
+		}
+		p.desc = strings.HasPrefix(name, "-")
+		p.columns = append(p.columns, spec.Columns[strings.TrimPrefix(name, "-")])
+	}
+	for _, key := range spec.Key {
+		if !contains(p.columns, key) {
+			p.columns = append(p.columns, key)
+		}
+	}
+
+	if fields := c.Query("fields"); fields != "" {
+		names := jsonNames(reflect.TypeOf(row))
+		for _, f := range strings.Split(fields, ",") {
+			f, err := parseEnum("fields", strings.TrimSpace(f), names)
+			if err != nil {
+				return nil, err
+			}
+			p.fields = append(p.fields, f)
+		}
+	}
+
+	if !paginate {
+		return p, nil
+	}
+	p.limit = defaultPageLimit
+	if limit := c.Query("limit"); limit != "" {
+		n, err := parseID("limit", limit)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxPageLimit {
+			return nil, &paramError{"limit", limit, "must be 1000 or less"}
+		}
+		p.limit = n
+	}
+	if s := c.Query("cursor"); s != "" {
+		cur, err := decodeCursor(s)
+		if err != nil || len(cur.After) != len(p.columns) {
+			return nil, &paramError{"cursor", s, "is not a valid cursor"}
+		}
+		if cur.Sort != p.sort {
+			return nil, &paramError{"cursor", s, "was issued for a different sort"}
+		}
+		p.after = cur.After
+	}
+	return p, nil
+}
+
+// where 句 (where は既存の条件、空でもよい) と order by, limit を返す
+func (p *pager) clause(where string, args ...interface{}) (string, []interface{}) {
+	var conds []string
+	if where != "" {
+		conds = append(conds, where)
+	}
+	order := "ASC"
+	op := ">"
+	if p.desc {
+		order, op = "DESC", "<"
+	}
+	if p.after != nil {
+		conds = append(conds, "("+strings.Join(p.columns, ", ")+") "+op+" ("+strings.TrimSuffix(strings.Repeat("?, ", len(p.columns)), ", ")+")")
+		args = append(args, p.after...)
+	}
+
+	var b strings.Builder
+	if len(conds) > 0 {
+		b.WriteString(" where " + strings.Join(conds, " and "))
+	}
+	for i, col := range p.columns {
+		if i == 0 {
+			b.WriteString(" order by ")
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(col + " " + order)
+	}
+	if p.limit > 0 {
+		// 次のページがあるか確かめるため1行多く取得する
+		b.WriteString(" limit ?")
+		args = append(args, p.limit+1)
+	}
+	return b.String(), args
+}
+
+// select に追加する並び替えの列 (Scan で読み取る)
+func (p *pager) keys() string {
+	return ", " + strings.Join(p.columns, ", ")
+}
+
+// dest に続けて並び替えの列を読み取る。limit を超えた行の場合は false を返す
+func (p *pager) Scan(rows *sql.Rows, dest ...interface{}) (bool, error) {
+	if p.limit > 0 && p.count == p.limit {
+		p.more = true
+		return false, nil
+	}
+	key := make([]interface{}, len(p.columns))
+	for i := range key {
+		dest = append(dest, &key[i])
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return false, err
+	}
+	p.last = key
+	p.count++
+	return true, nil
+}
+
+// 次のページがあれば Link ヘッダーに rel="next" の URL を追加する
+func (p *pager) Link(c *gin.Context) {
+	if !p.more {
+		return
+	}
+	after := make([]interface{}, len(p.last))
+	for i, v := range p.last {
+		switch v := v.(type) {
+		case time.Time:
+			after[i] = v.Format("2006-01-02 15:04:05")
+		case []byte:
+			after[i] = string(v)
+		default:
+			after[i] = v
+		}
+	}
+	b, _ := json.Marshal(cursor{Sort: p.sort, After: after})
+
+	u := *c.Request.URL
+	q := u.Query()
+	q.Set("cursor", base64.RawURLEncoding.EncodeToString(b))
+	u.RawQuery = q.Encode()
+	c.Writer.Header().Add("Link", "<"+u.RequestURI()+`>; rel="next"`)
+}
+
+// fields を指定した場合は各行をその項目だけの map にする
+func (p *pager) JSON(c *gin.Context, result interface{}) {
+	p.Link(c)
+	c.JSON(http.StatusOK, p.Fields(result))
+}
+
+// fields で指定した項目のみの map の配列にする (指定がなければそのまま返す)
+func (p *pager) Fields(result interface{}) interface{} {
+	v := reflect.ValueOf(result)
+	if p.fields == nil || v.IsNil() {
+		return result
+	}
+
+	rows := make([]map[string]interface{}, v.Len())
+	for i := range rows {
+		rows[i] = map[string]interface{}{}
+		row := v.Index(i)
+		for j := 0; j < row.NumField(); j++ {
+			if name := jsonName(row.Type().Field(j)); contains(p.fields, name) {
+				rows[i][name] = row.Field(j).Interface()
+			}
+		}
+	}
+	return rows
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var cur cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cur, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&cur); err != nil {
+		return cur, err
+	}
+	for i, v := range cur.After {
+		if n, ok := v.(json.Number); ok {
+			cur.After[i] = n.String()
+		}
+	}
+	return cur, nil
+}
+
+func jsonName(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return ""
+	}
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+func jsonNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		if name := jsonName(t.Field(i)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
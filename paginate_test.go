@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func pagerContext(query string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/api/v1/infections?"+query, nil)
+	return c, w
+}
+
+func TestNewPagerInvalidParams(t *testing.T) {
+	tests := []struct {
+		query, param string
+	}{
+		{"limit=0", "limit"},
+		{"limit=1001", "limit"},
+		{"limit=x", "limit"},
+		{"sort=place", "sort"},
+		{"fields=date,place", "fields"},
+		{"cursor=!!!", "cursor"},
+		{"cursor=" + encodeTestCursor(cursor{Sort: "date", After: []interface{}{"2022-01-01 00:00:00"}}), "cursor"},
+		{"sort=-date&cursor=" + encodeTestCursor(cursor{Sort: "date", After: []interface{}{"2022-01-01 00:00:00", "東京都"}}), "cursor"},
+	}
+
+	for _, tt := range tests {
+		c, _ := pagerContext(tt.query)
+		_, err := newPager(c, infectionSorts, infection{}, true)
+		pe, ok := err.(*paramError)
+		if !ok || pe.Param != tt.param {
+			t.Errorf("%s: expected invalid %s, got %v", tt.query, tt.param, err)
+		}
+	}
+}
+
+func TestPagerClause(t *testing.T) {
+	after := encodeTestCursor(cursor{Sort: "-npatients", After: []interface{}{120, "2022-01-01 00:00:00", "東京都"}})
+	c, _ := pagerContext("limit=10&sort=-npatients&cursor=" + after)
+	p, err := newPager(c, infectionSorts, infection{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clause, args := p.clause("date between ? and ?", "2022-01-01", "2022-01-31")
+	want := " where date between ? and ? and (npatients, date, name_jp) < (?, ?, ?) order by npatients DESC, date DESC, name_jp DESC limit ?"
+	if clause != want {
+		t.Errorf("clause = %q", clause)
+	}
+	wantArgs := []interface{}{"2022-01-01", "2022-01-31", "120", "2022-01-01 00:00:00", "東京都", 11}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args = %v", args)
+	}
+	if keys := p.keys(); keys != ", npatients, date, name_jp" {
+		t.Errorf("keys = %q", keys)
+	}
+
+	// CSV/XLSX の場合は limit, cursor を使わない
+	p, err = newPager(c, infectionSorts, infection{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clause, _ := p.clause(""); clause != " order by npatients DESC, date DESC, name_jp DESC" {
+		t.Errorf("clause = %q", clause)
+	}
+}
+
+func TestPagerLinkAndFields(t *testing.T) {
+	c, w := pagerContext("from=2022-01-01&to=2022-01-31&limit=1&fields=date,npatients")
+	p, err := newPager(c, infectionSorts, infection{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.last = []interface{}{date, []byte("東京都")}
+	p.more = true
+
+	p.JSON(c, []infection{{Date: date, NameJp: "東京都", Npatients: 100}})
+
+	link := w.Header().Get("Link")
+	if !strings.HasPrefix(link, "</api/v1/infections?") || !strings.HasSuffix(link, `>; rel="next"`) {
+		t.Fatalf("Link = %q", link)
+	}
+	u, _ := url.Parse(strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`))
+	if u.Query().Get("from") != "2022-01-01" || u.Query().Get("limit") != "1" {
+		t.Errorf("next URL lost the query: %s", u)
+	}
+	cur, err := decodeCursor(u.Query().Get("cursor"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cur.Sort != "date" || !reflect.DeepEqual(cur.After, []interface{}{"2022-01-01 00:00:00", "東京都"}) {
+		t.Errorf("cursor = %+v", cur)
+	}
+
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || len(rows[0]) != 2 || rows[0]["npatients"] != float64(100) {
+		t.Errorf("rows = %v", rows)
+	}
+}
+
+func encodeTestCursor(cur cursor) string {
+	c, _ := pagerContext("")
+	p := &pager{sort: cur.Sort, last: cur.After, more: true}
+	p.Link(c)
+	link := c.Writer.Header().Get("Link")
+	u, _ := url.Parse(strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`))
+	return u.Query().Get("cursor")
+}
+
+func TestPagerDefaultLimit(t *testing.T) {
+	c, _ := pagerContext("from=2022-01-01&to=2022-01-31")
+	p, err := newPager(c, infectionSorts, infection{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clause, args := p.clause("")
+	if !strings.HasSuffix(clause, " limit ?") || args[len(args)-1] != defaultPageLimit+1 {
+		t.Errorf("expected the default limit, got %q %v", clause, args)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 期間指定の上限日数
+const (
+	maxRangeDays      = 366     // 47都道府県分を返す場合
+	maxPlaceRangeDays = 366 * 5 // 1都道府県分を返す場合
+)
+
+// パス・クエリパラメータの検証エラー
+type paramError struct {
+	Param   string `json:"param"`
+	Value   string `json:"value"`
+	Message string `json:"message"`
+}
+
+func (e *paramError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %s", e.Param, e.Value, e.Message)
+}
+
+// yyyy-mm-dd 形式の日付
+func parseDate(name, value string) (time.Time, error) {
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, &paramError{name, value, "must be a date in YYYY-MM-DD format"}
+	}
+	return t, nil
+}
+
+// yyyy-mm 形式の年月 (その月の初日と最終日を返す)
+func parseMonth(name, value string) (time.Time, time.Time, error) {
+	t, err := time.Parse("2006-01", value)
+	if err != nil {
+		return time.Time{}, time.Time{}, &paramError{name, value, "must be a month in YYYY-MM format"}
+	}
+	return t, t.AddDate(0, 1, -1), nil
+}
+
+// yyyy 形式の年 (その年の初日と最終日を返す)
+func parseYear(name, value string) (time.Time, time.Time, error) {
+	t, err := time.Parse("2006", value)
+	if err != nil {
+		return time.Time{}, time.Time{}, &paramError{name, value, "must be a year in YYYY format"}
+	}
+	return t, t.AddDate(1, 0, -1), nil
+}
+
+// from <= to かつ maxDays 日以内の期間
+func parseDateRange(fromName, fromValue, toName, toValue string, maxDays int) (time.Time, time.Time, error) {
+	from, err := parseDate(fromName, fromValue)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to, err := parseDate(toName, toValue)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, &paramError{toName, toValue, fmt.Sprintf("must not be before %s", fromName)}
+	}
+	if maxDays > 0 && to.Sub(from) > time.Duration(maxDays)*24*time.Hour {
+		return time.Time{}, time.Time{}, &paramError{toName, toValue, fmt.Sprintf("range must be %d days or less", maxDays)}
+	}
+	return from, to, nil
+}
+
+// 正の整数のID
+func parseID(name, value string) (int, error) {
+	id, err := strconv.Atoi(value)
+	if err != nil || id <= 0 {
+		return 0, &paramError{name, value, "must be a positive integer"}
+	}
+	return id, nil
+}
+
+// 都道府県名・コード・ローマ字表記
+func parsePlace(name, value string) (prefecture, error) {
+	pref, ok := findPrefecture(value)
+	if !ok {
+		return prefecture{}, &paramError{name, value, "must be a prefecture name or code"}
+	}
+	return pref, nil
+}
+
+// カンマ区切りの都道府県 (空の場合は47都道府県)
+func parsePlaces(name, value string) ([]prefecture, error) {
+	if value == "" {
+		return prefectures, nil
+	}
+	var result []prefecture
+	for _, s := range strings.Split(value, ",") {
+		pref, err := parsePlace(name, strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, pref)
+	}
+	return result, nil
+}
+
+// 決められた値のいずれか
+func parseEnum(name, value string, allowed []string) (string, error) {
+	if !contains(allowed, value) {
+		return "", &paramError{name, value, "must be one of " + strings.Join(allowed, ", ")}
+	}
+	return value, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
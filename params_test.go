@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseDateRange(t *testing.T) {
+	from, to, err := parseDateRange("date1", "2022-01-01", "date2", "2022-01-31", maxRangeDays)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if from.Format("2006-01-02") != "2022-01-01" || to.Format("2006-01-02") != "2022-01-31" {
+		t.Errorf("unexpected range %v - %v", from, to)
+	}
+
+	tests := []struct {
+		date1, date2, param string
+	}{
+		{"2022-1-1", "2022-01-31", "date1"},
+		{"2022-01-01", "2022-01-32", "date2"},
+		{"2022-02-01", "2022-01-31", "date2"},
+		{"2020-01-01", "2022-01-31", "date2"},
+	}
+	for _, tt := range tests {
+		_, _, err := parseDateRange("date1", tt.date1, "date2", tt.date2, maxRangeDays)
+		pe, ok := err.(*paramError)
+		if !ok {
+			t.Errorf("%s - %s: expected paramError, got %v", tt.date1, tt.date2, err)
+			continue
+		}
+		if pe.Param != tt.param {
+			t.Errorf("%s - %s: expected param %s, got %s", tt.date1, tt.date2, tt.param, pe.Param)
+		}
+	}
+}
+
+func TestParseMonthAndYear(t *testing.T) {
+	begin, end, err := parseMonth("date", "2022-02")
+	if err != nil || begin.Format("2006-01-02") != "2022-02-01" || end.Format("2006-01-02") != "2022-02-28" {
+		t.Errorf("unexpected month range %v - %v (%v)", begin, end, err)
+	}
+	if _, _, err := parseMonth("date", "2022-13"); err == nil {
+		t.Errorf("Expected error for invalid month")
+	}
+
+	begin, end, err = parseYear("date", "2022")
+	if err != nil || begin.Format("2006-01-02") != "2022-01-01" || end.Format("2006-01-02") != "2022-12-31" {
+		t.Errorf("unexpected year range %v - %v (%v)", begin, end, err)
+	}
+	if _, _, err := parseYear("date", "2022%"); err == nil {
+		t.Errorf("Expected error for invalid year")
+	}
+}
+
+func TestParsePlace(t *testing.T) {
+	for _, value := range []string{"東京都", "13", "Tokyo", "tokyo"} {
+		pref, err := parsePlace("place", value)
+		if err != nil || pref.NameJp != "東京都" {
+			t.Errorf("%s: unexpected result %v (%v)", value, pref, err)
+		}
+	}
+	if _, err := parsePlace("place", "東京"); err == nil {
+		t.Errorf("Expected error for unknown place")
+	}
+}
+
+func TestRangeEndpointsBadRequest(t *testing.T) {
+	r := gin.New()
+	r.GET("/npatientsinmonth/:place/:date", SecondSecond)
+	r.GET("/npatientsinyear/:place/:date", SecondThird)
+	r.GET("/getInfection/:date1/:date2", ThirdSecond)
+	r.GET("/getnpatients/:place/:date1/:date2", ThirdThird)
+
+	tests := []struct {
+		path, param string
+	}{
+		{"/npatientsinmonth/東京都/2022-1", "date"},
+		{"/npatientsinmonth/Atlantis/2022-01", "place"},
+		{"/npatientsinyear/東京都/20%25", "date"},
+		{"/getInfection/2022-01-31/2022-01-01", "date2"},
+		{"/getInfection/2022-01-01/x", "date2"},
+		{"/getnpatients/北海道/2022-01-01/2022-13-01", "date2"},
+		{"/getnpatients/どこか/2022-01-01/2022-01-31", "place"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", tt.path, http.StatusBadRequest, w.Code)
+			continue
+		}
+		var body struct {
+			Error apiError `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Param != tt.param {
+			t.Errorf("%s: expected param %s, got %s (%v)", tt.path, tt.param, body.Error.Param, err)
+		}
+		if body.Error.Code != codeInvalidParameter {
+			t.Errorf("%s: expected code %s, got %s", tt.path, codeInvalidParameter, body.Error.Code)
+		}
+	}
+}
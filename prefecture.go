@@ -0,0 +1,98 @@
+package main
+
+import "strings"
+
+type prefecture struct {
+	Code   string `json:"code"`    // 都道府県コード (JIS X 0401)
+	NameJp string `json:"name_jp"` // 都道府県名
+	NameEn string `json:"name_en"` // ローマ字表記
+}
+
+// 47都道府県 (コード順)
+var prefectures = []prefecture{
+	{"01", "北海道", "Hokkaido"},
+	{"02", "青森県", "Aomori"},
+	{"03", "岩手県", "Iwate"},
+	{"04", "宮城県", "Miyagi"},
+	{"05", "秋田県", "Akita"},
+	{"06", "山形県", "Yamagata"},
+	{"07", "福島県", "Fukushima"},
+	{"08", "茨城県", "Ibaraki"},
+	{"09", "栃木県", "Tochigi"},
+	{"10", "群馬県", "Gunma"},
+	{"11", "埼玉県", "Saitama"},
+	{"12", "千葉県", "Chiba"},
+	{"13", "東京都", "Tokyo"},
+	{"14", "神奈川県", "Kanagawa"},
+	{"15", "新潟県", "Niigata"},
+	{"16", "富山県", "Toyama"},
+	{"17", "石川県", "Ishikawa"},
+	{"18", "福井県", "Fukui"},
+	{"19", "山梨県", "Yamanashi"},
+	{"20", "長野県", "Nagano"},
+	{"21", "岐阜県", "Gifu"},
+	{"22", "静岡県", "Shizuoka"},
+	{"23", "愛知県", "Aichi"},
+	{"24", "三重県", "Mie"},
+	{"25", "滋賀県", "Shiga"},
+	{"26", "京都府", "Kyoto"},
+	{"27", "大阪府", "Osaka"},
+	{"28", "兵庫県", "Hyogo"},
+	{"29", "奈良県", "Nara"},
+	{"30", "和歌山県", "Wakayama"},
+	{"31", "鳥取県", "Tottori"},
+	{"32", "島根県", "Shimane"},
+	{"33", "岡山県", "Okayama"},
+	{"34", "広島県", "Hiroshima"},
+	{"35", "山口県", "Yamaguchi"},
+	{"36", "徳島県", "Tokushima"},
+	{"37", "香川県", "Kagawa"},
+	{"38", "愛媛県", "Ehime"},
+	{"39", "高知県", "Kochi"},
+	{"40", "福岡県", "Fukuoka"},
+	{"41", "佐賀県", "Saga"},
+	{"42", "長崎県", "Nagasaki"},
+	{"43", "熊本県", "Kumamoto"},
+	{"44", "大分県", "Oita"},
+	{"45", "宮崎県", "Miyazaki"},
+	{"46", "鹿児島県", "Kagoshima"},
+	{"47", "沖縄県", "Okinawa"},
+}
+
+// 都道府県名・コード・ローマ字のいずれかから都道府県を探す
+func findPrefecture(s string) (prefecture, bool) {
+	for _, p := range prefectures {
+		if p.NameJp == s || p.Code == s || strings.EqualFold(p.NameEn, s) {
+			return p, true
+		}
+	}
+	return prefecture{}, false
+}
+
+type region struct {
+	Code        string   `json:"code"`        // ローマ字表記 (小文字)
+	NameJp      string   `json:"name_jp"`     // 地方名
+	Prefectures []string `json:"prefectures"` // 都道府県コード
+}
+
+// 8地方区分
+var regions = []region{
+	{"hokkaido", "北海道", []string{"01"}},
+	{"tohoku", "東北", []string{"02", "03", "04", "05", "06", "07"}},
+	{"kanto", "関東", []string{"08", "09", "10", "11", "12", "13", "14"}},
+	{"chubu", "中部", []string{"15", "16", "17", "18", "19", "20", "21", "22", "23"}},
+	{"kinki", "近畿", []string{"24", "25", "26", "27", "28", "29", "30"}},
+	{"chugoku", "中国", []string{"31", "32", "33", "34", "35"}},
+	{"shikoku", "四国", []string{"36", "37", "38", "39"}},
+	{"kyushu", "九州・沖縄", []string{"40", "41", "42", "43", "44", "45", "46", "47"}},
+}
+
+// 地方名またはローマ字から地方を探す
+func findRegion(s string) (region, bool) {
+	for _, r := range regions {
+		if r.NameJp == s || strings.EqualFold(r.Code, s) {
+			return r, true
+		}
+	}
+	return region{}, false
+}
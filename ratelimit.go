@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// RATE_LIMIT_RPS / RATE_LIMIT_BURST を指定しない場合の値
+const (
+	defaultRateLimit = 10  // 1秒あたりに回復するトークン
+	defaultRateBurst = 200 // 一度に使えるトークン
+)
+
+// 認証に失敗したリクエストが IP ごとの認証用のバケットから使うトークン数 (キーの総当たり対策)
+const authFailureCost = 10
+
+// 使われていないクライアントの状態を削除する間隔
+const rateLimitSweepInterval = 10 * time.Minute
+
+// -------------
+// クライアントごとの流量制限 (トークンバケット)
+// -------------
+
+// ルート (gin のパス) ごとのトークン数。指定しない場合は 1
+var routeCosts = map[string]float64{
+	// 47都道府県分を集計する
+	"/firstfirst/:date":               20,
+	"/firstsecond/:date":              20,
+	"/safearea/:date":                 20,
+	"/api/v1/risk/:date":              20,
+	"/api/v1/risk/:date/rates":        20,
+	"/api/v1/risk/:date/areas":        20,
+	"/api/v1/aggregate":               10,
+	"/getInfection/:date1/:date2":     10,
+	"/api/v1/infections":              10,
+	"/chart/:place":                   10,
+	"/api/v1/prefectures/:code/chart": 10,
+	"/api/v1/events/:id/impact":       10,
+	// 監視からのアクセスは制限しない
+	"/healthz": 0,
+	"/readyz":  0,
+	"/metrics": 0,
+}
+
+var rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "corona_rate_limited_total",
+	Help: "Requests rejected with 429 by route template.",
+}, []string{"route"})
+
+// nil なら制限しない (main で configureRateLimit により設定する)
+var limiter *rateLimiter
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// クライアントごとの利用状況 (プロセスの起動から)
+type clientUsage struct {
+	Client   string    `json:"client"`   // key:<キーの名前>、ip:<IP>、auth:<IP> (認証の失敗)
+	Requests uint64    `json:"requests"` // 受け付けたリクエスト数
+	Cost     float64   `json:"cost"`     // 使ったトークン数
+	Limited  uint64    `json:"limited"`  // 429 にしたリクエスト数
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	usage     map[string]*clientUsage
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newRateLimiter(rate, burst float64) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: burst, buckets: map[string]*tokenBucket{}, usage: map[string]*clientUsage{}, now: time.Now}
+}
+
+// 環境変数から読み込む。RATE_LIMIT_RPS=0 なら制限しない
+func configureRateLimit() error {
+	rate, burst := float64(defaultRateLimit), float64(defaultRateBurst)
+	if s := os.Getenv("RATE_LIMIT_RPS"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid RATE_LIMIT_RPS %q", s)
+		}
+		rate = v
+	}
+	if s := os.Getenv("RATE_LIMIT_BURST"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v < 1 {
+			return fmt.Errorf("invalid RATE_LIMIT_BURST %q", s)
+		}
+		burst = v
+	}
+	if rate == 0 {
+		limiter = nil
+		return nil
+	}
+	limiter = newRateLimiter(rate, burst)
+	return nil
+}
+
+// cost 分のトークンを使う。足りない場合は使えるようになるまでの時間を返す
+func (l *rateLimiter) Allow(client string, cost float64) (bool, float64, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// バースト (一度に使える量) を超えるルートも、満タンなら使えるようにする
+	cost = math.Min(cost, l.burst)
+	b, u := l.refill(client)
+	if b.tokens < cost {
+		u.Limited++
+		return false, b.tokens, l.wait(b, cost)
+	}
+	b.tokens -= cost
+	u.Requests++
+	u.Cost += cost
+	return true, b.tokens, 0
+}
+
+// cost 分のトークンが残っているかを確認する (使わない)。足りない場合は使えるようになるまでの時間を返す
+func (l *rateLimiter) Check(client string, cost float64) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cost = math.Min(cost, l.burst)
+	b, u := l.refill(client)
+	if b.tokens < cost {
+		u.Limited++
+		return false, l.wait(b, cost)
+	}
+	return true, 0
+}
+
+// 前回からの経過時間分のトークンを足したバケットと、利用状況を返す
+func (l *rateLimiter) refill(client string) (*tokenBucket, *clientUsage) {
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	u, ok := l.usage[client]
+	if !ok {
+		u = &clientUsage{Client: client}
+		l.usage[client] = u
+	}
+	u.LastSeen = now
+	return b, u
+}
+
+func (l *rateLimiter) wait(b *tokenBucket, cost float64) time.Duration {
+	return time.Duration((cost - b.tokens) / l.rate * float64(time.Second))
+}
+
+// 満タンまで回復したバケットと、1日使われていないクライアントの利用状況を削除する
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, client)
+		}
+	}
+	for client, u := range l.usage {
+		if now.Sub(u.LastSeen) > 24*time.Hour {
+			delete(l.usage, client)
+		}
+	}
+}
+
+// 使ったトークンの多い順
+func (l *rateLimiter) Usage() []clientUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	usage := make([]clientUsage, 0, len(l.usage))
+	for _, u := range l.usage {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Cost != usage[j].Cost {
+			return usage[i].Cost > usage[j].Cost
+		}
+		return usage[i].Client < usage[j].Client
+	})
+	return usage
+}
+
+// 認証されたクライアントはキーごと、それ以外は IP ごとに制限する (authMiddleware の後に置く)
+func rateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		l := limiter
+		if l == nil {
+			c.Next()
+			return
+		}
+		cost, ok := routeCosts[c.FullPath()]
+		if !ok {
+			cost = 1
+		}
+		if cost == 0 {
+			c.Next()
+			return
+		}
+
+		client := "ip:" + c.ClientIP()
+		if v, ok := c.Get(principalKey); ok {
+			client = "key:" + v.(principal).Name
+		}
+
+		allowed, remaining, wait := l.Allow(client, cost)
+		c.Header("RateLimit-Limit", strconv.FormatFloat(l.burst, 'f', -1, 64))
+		c.Header("RateLimit-Remaining", strconv.FormatFloat(math.Floor(remaining), 'f', -1, 64))
+		if !allowed {
+			abortRateLimited(c, wait)
+			return
+		}
+		c.Next()
+	}
+}
+
+// authMiddleware の前に置く。API キー・トークンを送ってきた IP の認証用のトークンが失敗1回分に満たなければ、
+// キーを確認 (DB に問い合わせ) せずに 429 にする。認証に失敗した (401) 場合にトークンを使う
+// (匿名のリクエストで IP のバケットを使い切っても、正しいキーは使えるように別のバケットにする)
+func authFailureLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		l := limiter
+		if l == nil || (c.GetHeader("X-API-Key") == "" && c.GetHeader("Authorization") == "") {
+			c.Next()
+			return
+		}
+
+		client := "auth:" + c.ClientIP()
+		if ok, wait := l.Check(client, authFailureCost); !ok {
+			abortRateLimited(c, wait)
+			return
+		}
+		c.Next()
+		if c.Writer.Status() == http.StatusUnauthorized {
+			l.Allow(client, authFailureCost)
+		}
+	}
+}
+
+func abortRateLimited(c *gin.Context, wait time.Duration) {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	rateLimited.WithLabelValues(route).Inc()
+	abortWithError(c, errRateLimited(retryAfter))
+}
+
+// クライアントごとの利用状況 (admin のみ)
+func RateLimitUsage(c *gin.Context) {
+	if limiter == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false, "clients": []clientUsage{}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": true,
+		"rate":    limiter.rate,
+		"burst":   limiter.burst,
+		"clients": limiter.Usage(),
+	})
+}
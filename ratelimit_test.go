@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// テストの間だけ流量制限を有効にする (時刻は clock で進める)
+func withRateLimit(t *testing.T, rate, burst float64) (*rateLimiter, *time.Time) {
+	t.Helper()
+	clock := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newRateLimiter(rate, burst)
+	l.now = func() time.Time { return clock }
+	saved := limiter
+	limiter = l
+	t.Cleanup(func() { limiter = saved })
+	return l, &clock
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	l, clock := withRateLimit(t, 1, 10)
+
+	if ok, remaining, _ := l.Allow("ip:192.0.2.1", 8); !ok || remaining != 2 {
+		t.Fatalf("expected the first request to be allowed, remaining %v", remaining)
+	}
+	ok, _, wait := l.Allow("ip:192.0.2.1", 5)
+	if ok || wait != 3*time.Second {
+		t.Errorf("expected to wait 3s, got %v %v", ok, wait)
+	}
+	// 他のクライアントには影響しない
+	if ok, _, _ := l.Allow("key:partner", 5); !ok {
+		t.Error("expected another client to be allowed")
+	}
+
+	*clock = clock.Add(3 * time.Second)
+	if ok, _, _ := l.Allow("ip:192.0.2.1", 5); !ok {
+		t.Error("expected tokens to be refilled")
+	}
+	// バーストより大きいコストは満タンのときに使える
+	*clock = clock.Add(time.Minute)
+	if ok, remaining, _ := l.Allow("ip:192.0.2.1", 50); !ok || remaining != 0 {
+		t.Errorf("expected a request costing more than the burst to be allowed when full, remaining %v", remaining)
+	}
+
+	usage := l.Usage()
+	if len(usage) != 2 || usage[0].Client != "ip:192.0.2.1" || usage[0].Requests != 3 || usage[0].Cost != 23 || usage[0].Limited != 1 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l, clock := withRateLimit(t, 1, 10)
+	l.Allow("ip:192.0.2.1", 1)
+
+	*clock = clock.Add(rateLimitSweepInterval)
+	l.Allow("ip:192.0.2.2", 1)
+	if _, ok := l.buckets["ip:192.0.2.1"]; ok {
+		t.Error("expected the refilled bucket to be removed")
+	}
+	if len(l.Usage()) != 2 {
+		t.Error("expected usage to be kept")
+	}
+
+	*clock = clock.Add(25 * time.Hour)
+	l.Allow("ip:192.0.2.2", 1)
+	if usage := l.Usage(); len(usage) != 1 || usage[0].Client != "ip:192.0.2.2" {
+		t.Errorf("expected idle clients to be removed, got %+v", usage)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	withAuth(t, testAuthConfig())
+	withRateLimit(t, 1, 30)
+	r := setupRouter()
+
+	get := func(path, key string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// /api/v1/prefectures は 1 トークン
+	for i := 0; i < 30; i++ {
+		if w := get("/api/v1/prefectures", ""); w.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, w.Code)
+		}
+	}
+	w := get("/api/v1/prefectures", "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected 429 with Retry-After, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	var body struct {
+		Error apiError `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body.Error.Code != codeRateLimited {
+		t.Errorf("unexpected error %s", w.Body.String())
+	}
+
+	// キーごとに別のバケットを使う。監視のルートは制限しない
+	if w := get("/api/v1/prefectures", "reader-key"); w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != "29" {
+		t.Errorf("expected the key to have its own bucket, got %d %q", w.Code, w.Header().Get("RateLimit-Remaining"))
+	}
+	if w := get("/healthz", ""); w.Code != http.StatusOK {
+		t.Errorf("expected /healthz not to be limited, got %d", w.Code)
+	}
+}
+
+// 不正なキーを試し続けると、キーを確認する前に 429 になる
+func TestAuthFailureLimit(t *testing.T) {
+	withAuth(t, testAuthConfig())
+	_, clock := withRateLimit(t, 1, 30)
+	r := setupRouter()
+
+	get := func(key string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/v1/prefectures", nil)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// 30 トークンで認証の失敗 3 回分
+	for i := 0; i < 3; i++ {
+		if w := get("bogus-key"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i, w.Code)
+		}
+	}
+	w := get("another-bogus-key")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "10" {
+		t.Errorf("expected 429 with Retry-After, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	usage := limiter.Usage()
+	if len(usage) != 1 || usage[0].Client != "auth:" || usage[0].Cost != 3*authFailureCost || usage[0].Limited != 1 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+
+	// 回復すれば正しいキーで使える
+	*clock = clock.Add(authFailureCost * time.Second)
+	if w := get("reader-key"); w.Code != http.StatusOK {
+		t.Errorf("expected 200 after the bucket refilled, got %d", w.Code)
+	}
+}
+
+func TestRateLimitUsage(t *testing.T) {
+	withAuth(t, testAuthConfig())
+	withRateLimit(t, 1, 30)
+	limiter.Allow("key:dashboard", 3)
+	r := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/admin/usage", nil)
+	req.Header.Set("X-API-Key", "reader-key")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a reader, got %d", w.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/admin/usage", nil)
+	req.Header.Set("X-API-Key", "admin-key")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var body struct {
+		Enabled bool          `json:"enabled"`
+		Clients []clientUsage `json:"clients"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || !body.Enabled || len(body.Clients) == 0 || body.Clients[0].Client != "key:dashboard" {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+}
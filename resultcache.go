@@ -0,0 +1,142 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// -------------
+// 集計結果のキャッシュ (LRU + TTL)
+// -------------
+
+// 前日比・危険度の計算結果 (朝は前日分へのアクセスが集中する)
+var analyticsCache = newResultCache(256, 10*time.Minute)
+
+type resultCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List // 先頭が最近使ったもの
+	entries  map[string]*list.Element
+	stats    cacheStats
+	// データごとに Invalidate した回数 (集計中に取り込まれた古い結果を保存しないため)
+	generations map[string]uint64
+}
+
+type cacheEntry struct {
+	key     string
+	sources []string // 依存するデータ (取り込み時に削除する)
+	value   interface{}
+	expires time.Time
+}
+
+type cacheStats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`     // 容量を超えたため削除
+	Expirations   uint64 `json:"expirations"`   // TTL を過ぎたため削除
+	Invalidations uint64 `json:"invalidations"` // 取り込みにより削除
+	Entries       int    `json:"entries"`
+	Capacity      int    `json:"capacity"`
+}
+
+func newResultCache(capacity int, ttl time.Duration) *resultCache {
+	return &resultCache{capacity: capacity, ttl: ttl, order: list.New(), entries: map[string]*list.Element{}, generations: map[string]uint64{}}
+}
+
+// key は "ハンドラ名:パラメータ" のようにする
+func (rc *resultCache) Get(key string) (interface{}, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	el, ok := rc.entries[key]
+	if !ok {
+		rc.stats.Misses++
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Now().After(e.expires) {
+		rc.remove(el)
+		rc.stats.Expirations++
+		rc.stats.Misses++
+		return nil, false
+	}
+	rc.order.MoveToFront(el)
+	rc.stats.Hits++
+	return e.value, true
+}
+
+// sources の世代。DB に問い合わせる前に取得して Add に渡す
+func (rc *resultCache) Generation(sources ...string) uint64 {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.generation(sources)
+}
+
+// generation は問い合わせる前の Generation (sources がなければ 0)。その後に Invalidate された場合は
+// 取り込み前のデータによる結果のため保存しない。value はキャッシュした後に変更しないこと
+func (rc *resultCache) Add(key string, sources []string, generation uint64, value interface{}) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.generation(sources) != generation {
+		return
+	}
+	if el, ok := rc.entries[key]; ok {
+		rc.remove(el)
+	}
+	rc.entries[key] = rc.order.PushFront(&cacheEntry{key: key, sources: sources, value: value, expires: time.Now().Add(rc.ttl)})
+	for rc.order.Len() > rc.capacity {
+		rc.remove(rc.order.Back())
+		rc.stats.Evictions++
+	}
+}
+
+// source に依存する結果をすべて削除する
+func (rc *resultCache) Invalidate(source string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.generations[source]++
+	for el := rc.order.Front(); el != nil; {
+		next := el.Next()
+		if contains(el.Value.(*cacheEntry).sources, source) {
+			rc.remove(el)
+			rc.stats.Invalidations++
+		}
+		el = next
+	}
+}
+
+func (rc *resultCache) Stats() cacheStats {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	stats := rc.stats
+	stats.Entries = rc.order.Len()
+	stats.Capacity = rc.capacity
+	return stats
+}
+
+// 世代は増えるだけなので、合計が変わらなければどのデータも Invalidate されていない
+func (rc *resultCache) generation(sources []string) uint64 {
+	var g uint64
+	for _, source := range sources {
+		g += rc.generations[source]
+	}
+	return g
+}
+
+func (rc *resultCache) remove(el *list.Element) {
+	rc.order.Remove(el)
+	delete(rc.entries, el.Value.(*cacheEntry).key)
+}
+
+// キャッシュのヒット率などを返す
+func CacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"analytics": analyticsCache.Stats()})
+}
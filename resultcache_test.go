@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResultCacheLRU(t *testing.T) {
+	rc := newResultCache(2, time.Minute)
+	rc.Add("a", []string{sourceInfections}, 0, 1)
+	rc.Add("b", []string{sourceInfections}, 0, 2)
+	rc.Get("a") // b が最も古くなる
+	rc.Add("c", []string{sourceInfections}, 0, 3)
+
+	if _, ok := rc.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	for key, want := range map[string]int{"a": 1, "c": 3} {
+		if v, ok := rc.Get(key); !ok || v != want {
+			t.Errorf("Get(%s) = %v, %v", key, v, ok)
+		}
+	}
+
+	stats := rc.Stats()
+	if stats.Hits != 3 || stats.Misses != 1 || stats.Evictions != 1 || stats.Entries != 2 || stats.Capacity != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestResultCacheTTL(t *testing.T) {
+	rc := newResultCache(2, 10*time.Millisecond)
+	rc.Add("a", []string{sourceInfections}, 0, 1)
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := rc.Get("a"); ok {
+		t.Error("a should have expired")
+	}
+	if stats := rc.Stats(); stats.Expirations != 1 || stats.Entries != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+// 集計中に取り込まれた (Invalidate された) 場合は取り込み前のデータによる結果を保存しない
+func TestResultCacheAddAfterInvalidate(t *testing.T) {
+	rc := newResultCache(10, time.Minute)
+	infections := rc.Generation(sourceInfections)
+	events := rc.Generation(sourceEvents)
+	both := rc.Generation(sourceInfections, sourceMedical)
+
+	rc.Invalidate(sourceInfections)
+	rc.Add("FirstFirst:2022-01-01", []string{sourceInfections}, infections, 1)
+	rc.Add("FifthSecond:2022-01-01", []string{sourceInfections, sourceMedical}, both, 2)
+	rc.Add("other", []string{sourceEvents}, events, 3)
+
+	for key, cached := range map[string]bool{"FirstFirst:2022-01-01": false, "FifthSecond:2022-01-01": false, "other": true} {
+		if _, ok := rc.Get(key); ok != cached {
+			t.Errorf("%s: expected cached=%v", key, cached)
+		}
+	}
+
+	// 取り込み後に問い合わせた結果は保存する
+	rc.Add("FirstFirst:2022-01-01", []string{sourceInfections}, rc.Generation(sourceInfections), 1)
+	if _, ok := rc.Get("FirstFirst:2022-01-01"); !ok {
+		t.Error("expected a result computed after the import to be cached")
+	}
+}
+
+func TestResultCacheInvalidate(t *testing.T) {
+	rc := newResultCache(10, time.Minute)
+	rc.Add("FirstFirst:2022-01-01", []string{sourceInfections}, 0, 1)
+	rc.Add("FifthSecond:2022-01-01", []string{sourceInfections, sourceMedical}, 0, 2)
+	rc.Add("other", []string{sourceEvents}, 0, 3)
+
+	rc.Invalidate(sourceMedical)
+	if _, ok := rc.Get("FifthSecond:2022-01-01"); ok {
+		t.Error("FifthSecond should have been invalidated")
+	}
+	if _, ok := rc.Get("FirstFirst:2022-01-01"); !ok {
+		t.Error("FirstFirst should not have been invalidated")
+	}
+
+	rc.Invalidate(sourceInfections)
+	if stats := rc.Stats(); stats.Invalidations != 2 || stats.Entries != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCacheStatsRequiresAdmin(t *testing.T) {
+	withAuth(t, testAuthConfig())
+	r := setupRouter()
+
+	for key, want := range map[string]int{"": http.StatusUnauthorized, "editor-key": http.StatusForbidden, "admin-key": http.StatusOK} {
+		req, _ := http.NewRequest("GET", "/debug/cache", nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("key %q: expected %d, got %d", key, want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const paramAliasesKey = "param_aliases"
+
+// -------------
+// API v1
+// -------------
+
+// 既存のハンドラをリソース名の URL に割り当てる
+func registerV1(v1 *gin.RouterGroup) {
+	// 感染者数
+	v1.GET("/infections", cached(infectionsCache), withParams(ThirdSecond, map[string]string{"date1": "from", "date2": "to"})) // 期間内の47都道府県の感染者数
+	v1.GET("/infections/:date/total", cached(infectionsCache), CountOfPatients)                                                // 日の感染者の合計
+	v1.GET("/aggregate", cached(infectionsCache), Aggregate)                                                                   // 日・週・ISO週・月・四半期・年ごとに集計
+
+	// 危険度
+	v1.GET("/risk/:date", cached(infectionsCache), FirstFirst)        // 前日比・前々日比による危険度
+	v1.GET("/risk/:date/rates", cached(infectionsCache), FirstSecond) // 前日比・前々日比による危険度 (増加率つき)
+	v1.GET("/risk/:date/areas", cached(areasCache), FifthSecond)      // 病院数と感染者数による危険度
+
+	// 都道府県
+	v1.GET("/prefectures", Prefectures)                                                                                                                  // 47都道府県の一覧
+	v1.GET("/prefectures/:code/series", cached(seriesCache), withParams(ThirdThird, map[string]string{"place": "code", "date1": "from", "date2": "to"})) // 期間内の感染者数
+	v1.GET("/prefectures/:code/recent/:date", cached(seriesCache), withParams(SecondFirst, map[string]string{"place": "code"}))                          // ここ7日間の感染者数
+	v1.GET("/prefectures/:code/diffs/:date", cached(infectionsCache), withParams(DiffAdd, map[string]string{"place": "code"}))                           // ここ6日間の前日比
+	v1.GET("/prefectures/:code/months/:month", cached(infectionsCache), withParams(SecondSecond, map[string]string{"place": "code", "date": "month"}))   // 月の感染者数
+	v1.GET("/prefectures/:code/years/:year", cached(infectionsCache), withParams(SecondThird, map[string]string{"place": "code", "date": "year"}))       // 年の感染者数
+	v1.GET("/prefectures/:code/chart", cached(chartCache), withParams(Chart, map[string]string{"place": "code"}))                                        // 感染者数のグラフ (PNG/SVG)
+	v1.GET("/prefectures/:code/facilities", cached(medicalCache), withParams(ForthFirst, map[string]string{"place": "code"}))                            // 都道府県内の医療機関
+	v1.GET("/prefectures/:code/events", cached(eventsCache), withParams(ShowAll, map[string]string{"prefecture": "code"}))                               // 都道府県 (または全国) が対象のメモ
+
+	// 医療機関
+	v1.GET("/facilities", cached(medicalCache), withParams(FifthFirst, map[string]string{"place": "place", "status": "status"})) // 住所と状況で医療機関を検索
+	v1.GET("/facilities/:name", cached(medicalCache), withParams(ForthSecond, map[string]string{"hospital_name": "name"}))       // 医療機関の詳細
+
+	// コロナに関するメモ
+	v1.POST("/events", requireRole(roleEditor), Create)
+	v1.GET("/events", cached(eventsCache), ShowAll)
+	v1.GET("/events/:id", cached(eventsCache), Show)
+	v1.PATCH("/events/:id", requireRole(roleEditor), Update)
+	v1.DELETE("/events/:id", requireRole(roleEditor), Delete)
+	v1.GET("/events/:id/impact", cached(seriesCache), EventImpact) // 前後の感染者数の傾向の比較
+
+	// データをimport
+	v1.POST("/imports/infections", requireRole(roleAdmin), Import)
+	v1.POST("/imports/medical", requireRole(roleAdmin), ImportMedical)
+}
+
+// 47都道府県の一覧
+func Prefectures(c *gin.Context) {
+	c.Header("Cache-Control", cacheControl("public, max-age=86400")) // 変わらないので1日キャッシュさせる
+	c.JSON(http.StatusOK, prefectures)
+}
+
+// ハンドラが参照するパスパラメータ名 (キー) に、v1 のパスパラメータまたはクエリパラメータ (値) を詰め替える
+func withParams(h gin.HandlerFunc, names map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for param, source := range names {
+			value, ok := c.Params.Get(source)
+			if !ok {
+				value = c.Query(source)
+			}
+			c.Params = append(c.Params, gin.Param{Key: param, Value: value})
+		}
+		// エラーレスポンスでは v1 のパラメータ名を返す
+		c.Set(paramAliasesKey, names)
+		h(c)
+	}
+}
+
+// 旧URL に Deprecation ヘッダーと後継の URL を付ける。
+// successor の {name} は旧URL のパスパラメータ name の値に置き換える
+func deprecated(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+successorURL(successor, c.Params, c.Request.URL.RawQuery)+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
+
+// 旧URL のクエリパラメータ (limit, format など) は後継の URL にも付ける
+func successorURL(template string, params gin.Params, rawQuery string) string {
+	path, query, _ := strings.Cut(template, "?")
+	for _, p := range params {
+		path = strings.ReplaceAll(path, "{"+p.Key+"}", url.PathEscape(p.Value))
+		query = strings.ReplaceAll(query, "{"+p.Key+"}", url.QueryEscape(p.Value))
+	}
+	if rawQuery != "" {
+		if query != "" {
+			query += "&"
+		}
+		query += rawQuery
+	}
+	if query == "" {
+		return path
+	}
+	return path + "?" + query
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPrefectures(t *testing.T) {
+	r := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/api/v1/prefectures", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var result []prefecture
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 47 || result[12].NameJp != "東京都" {
+		t.Errorf("unexpected prefectures %v", result)
+	}
+}
+
+func TestV1ParamNames(t *testing.T) {
+	r := setupRouter()
+
+	tests := []struct {
+		path, param string
+	}{
+		{"/api/v1/infections?from=2022-01-31&to=2022-01-01", "to"},
+		{"/api/v1/infections?from=x&to=2022-01-01", "from"},
+		{"/api/v1/prefectures/99/series?from=2022-01-01&to=2022-01-31", "code"},
+		{"/api/v1/prefectures/13/months/2022-13", "month"},
+		{"/api/v1/prefectures/13/years/22", "year"},
+		{"/api/v1/infections/2022-01-32/total", "date"},
+		{"/api/v1/events/abc", "id"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", tt.path, http.StatusBadRequest, w.Code)
+			continue
+		}
+		var body struct {
+			Error apiError `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Param != tt.param {
+			t.Errorf("%s: expected param %s, got %s (%v)", tt.path, tt.param, body.Error.Param, err)
+		}
+		if w.Header().Get("Deprecation") != "" {
+			t.Errorf("%s: unexpected Deprecation header", tt.path)
+		}
+	}
+}
+
+func TestLegacyRoutesDeprecated(t *testing.T) {
+	r := setupRouter()
+
+	tests := []struct {
+		path, link string
+	}{
+		{"/getInfection/2022-01-31/2022-01-01", "/api/v1/infections?from=2022-01-31&to=2022-01-01"},
+		{"/getnpatients/13/2022-01-01/2022-01-31?limit=10", "/api/v1/prefectures/13/series?from=2022-01-01&to=2022-01-31&limit=10"},
+		{"/npatientsinmonth/13/2022-13", "/api/v1/prefectures/13/months/2022-13"},
+		{"/show/abc", "/api/v1/events/abc"},
+		{"/medical/%E7%97%85%E9%99%A2%20A", "/api/v1/facilities/%E7%97%85%E9%99%A2%20A"},
+		{"/chart/99?days=30", "/api/v1/prefectures/99/chart?days=30"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Header().Get("Deprecation") != "true" {
+			t.Errorf("%s: expected Deprecation header, got %q", tt.path, w.Header().Get("Deprecation"))
+		}
+		if want := "<" + tt.link + `>; rel="successor-version"`; w.Header().Get("Link") != want {
+			t.Errorf("%s: expected Link header %q, got %q", tt.path, want, w.Header().Get("Link"))
+		}
+	}
+}
+
+func TestSuccessorURL(t *testing.T) {
+	params := gin.Params{{Key: "place", Value: "東京都"}, {Key: "date1", Value: "2022-01-01"}, {Key: "date2", Value: "a&b"}}
+	got := successorURL("/api/v1/prefectures/{place}/series?from={date1}&to={date2}", params, "")
+	if want := "/api/v1/prefectures/%E6%9D%B1%E4%BA%AC%E9%83%BD/series?from=2022-01-01&to=a%26b"; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+	if got := successorURL("/api/v1/events", nil, "q=a"); got != "/api/v1/events?q=a" {
+		t.Errorf("unexpected %s", got)
+	}
+	if _, err := url.Parse(got); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewEndpointsNotLegacy(t *testing.T) {
+	r := setupRouter()
+	for _, path := range []string{"/aggregate"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// -------------
+// CORS・セキュリティヘッダー・プロキシ
+// -------------
+
+type securityConfig struct {
+	allowedOrigins []string // CORS を許可するオリジン ("*" ならすべて)
+	trustedProxies []string // X-Forwarded-For を信用するプロキシの IP・CIDR (空なら信用しない)
+}
+
+var security securityConfig
+
+// 環境変数から読み込む (カンマ区切り)
+//   - CORS_ALLOWED_ORIGINS: 例 https://partner.example.com,https://admin.example.com
+//   - TRUSTED_PROXIES: 例 10.0.0.0/8,192.168.1.10
+func configureSecurity() error {
+	config := securityConfig{
+		allowedOrigins: splitList(os.Getenv("CORS_ALLOWED_ORIGINS")),
+		trustedProxies: splitList(os.Getenv("TRUSTED_PROXIES")),
+	}
+	for _, p := range config.trustedProxies {
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			return fmt.Errorf("invalid TRUSTED_PROXIES entry %q", p)
+		}
+	}
+	security = config
+	return nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// ルート (gin のパス) ごとの Content-Security-Policy。指定しない場合は apiCSP
+var contentSecurityPolicies = map[string]string{
+	"/":                    dashboardCSP,
+	"/dashboard/*filepath": dashboardCSP,
+	"/docs":                swaggerUICSP,
+}
+
+// JSON・画像にはスクリプトを実行させない
+const apiCSP = "default-src 'none'; frame-ancestors 'none'"
+
+// ダッシュボードは同じオリジンのファイルと API のみ使う
+const dashboardCSP = "default-src 'self'; img-src 'self' data:; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// Swagger UI は unpkg.com から読み込み、初期化のスクリプトのみインラインで許可する
+var swaggerUICSP = "default-src 'self'; script-src https://unpkg.com '" + scriptHash(swaggerUIScript) + "'; style-src https://unpkg.com 'unsafe-inline'; img-src 'self' data:; object-src 'none'; base-uri 'self'; frame-ancestors 'none'"
+
+func scriptHash(script string) string {
+	sum := sha256.Sum256([]byte(script))
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// すべてのレスポンスに付ける
+func securityHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		csp, ok := contentSecurityPolicies[c.FullPath()]
+		if !ok {
+			csp = apiCSP
+		}
+		h.Set("Content-Security-Policy", csp)
+		// HTTP のレスポンスの HSTS はブラウザが無視するため、HTTPS のときのみ付ける
+		// (X-Forwarded-Proto は TRUSTED_PROXIES のプロキシで TLS を終端した場合のみ信用する)
+		if c.Request.TLS != nil || (c.GetHeader("X-Forwarded-Proto") == "https" && fromTrustedProxy(c.Request.RemoteAddr)) {
+			h.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		}
+		c.Next()
+	}
+}
+
+// 接続元 (RemoteAddr) が TRUSTED_PROXIES の IP・CIDR に含まれるか
+func fromTrustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, p := range security.trustedProxies {
+		if _, network, err := net.ParseCIDR(p); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if ip.Equal(net.ParseIP(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// CORS のプリフライトで許可するメソッド・ヘッダー
+const (
+	corsAllowMethods  = "GET, HEAD, POST, PATCH, DELETE"
+	corsAllowHeaders  = "Accept, Content-Type, Authorization, X-API-Key, X-Request-ID, If-None-Match, If-Modified-Since"
+	corsExposeHeaders = "ETag, Last-Modified, Link, Deprecation, Retry-After, RateLimit-Limit, RateLimit-Remaining, X-Request-ID"
+)
+
+// CORS_ALLOWED_ORIGINS のオリジンからのリクエストを許可する。
+// プリフライト (OPTIONS) は認証・流量制限の前にここで 204 を返す
+func corsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		h := c.Writer.Header()
+		h.Add("Vary", "Origin")
+		if !originAllowed(origin) {
+			c.Next() // ブラウザがレスポンスを読めないようにヘッダーを付けない
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", origin)
+
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", corsAllowMethods)
+			h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			h.Set("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		c.Next()
+	}
+}
+
+func originAllowed(origin string) bool {
+	for _, o := range security.allowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
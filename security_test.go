@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// テストの間だけ CORS・プロキシの設定を差し替える
+func withSecurity(t *testing.T, config securityConfig) {
+	t.Helper()
+	saved := security
+	security = config
+	t.Cleanup(func() { security = saved })
+}
+
+func TestConfigureSecurity(t *testing.T) {
+	withSecurity(t, securityConfig{})
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", " https://partner.example.com , https://admin.example.com")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,192.168.1.10")
+	if err := configureSecurity(); err != nil {
+		t.Fatal(err)
+	}
+	if len(security.allowedOrigins) != 2 || security.allowedOrigins[0] != "https://partner.example.com" || len(security.trustedProxies) != 2 {
+		t.Errorf("unexpected config %+v", security)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "proxy.internal")
+	if err := configureSecurity(); err == nil {
+		t.Error("expected an error for a host name")
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	r := setupRouter()
+
+	tests := []struct {
+		path string
+		csp  string
+	}{
+		{"/api/v1/prefectures", apiCSP},
+		{"/", dashboardCSP},
+		{"/dashboard/app.js", dashboardCSP},
+		{"/docs", swaggerUICSP},
+		{"/no/such/path", apiCSP},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Header().Get("Content-Security-Policy"); got != tt.csp {
+			t.Errorf("%s: Content-Security-Policy = %q", tt.path, got)
+		}
+		if w.Header().Get("X-Content-Type-Options") != "nosniff" || w.Header().Get("X-Frame-Options") != "DENY" {
+			t.Errorf("%s: missing security headers %v", tt.path, w.Header())
+		}
+		if w.Header().Get("Strict-Transport-Security") != "" {
+			t.Errorf("%s: HSTS must not be sent over HTTP", tt.path)
+		}
+	}
+
+	// X-Forwarded-Proto は TRUSTED_PROXIES からの接続のみ信用する
+	withSecurity(t, securityConfig{trustedProxies: []string{"10.0.0.0/8", "192.168.1.10"}})
+	for _, tt := range []struct {
+		remoteAddr string
+		hsts       bool
+	}{
+		{"10.1.2.3:54321", true},
+		{"192.168.1.10:54321", true},
+		{"203.0.113.5:54321", false},
+		{"192.168.1.11:54321", false},
+	} {
+		req, _ := http.NewRequest("GET", "/api/v1/prefectures", nil)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.RemoteAddr = tt.remoteAddr
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := strings.HasPrefix(w.Header().Get("Strict-Transport-Security"), "max-age="); got != tt.hsts {
+			t.Errorf("%s: expected HSTS %v, got %v", tt.remoteAddr, tt.hsts, w.Header())
+		}
+	}
+}
+
+// Swagger UI のインラインスクリプトが CSP のハッシュと一致すること
+func TestSwaggerUICSP(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/docs", nil)
+	w := httptest.NewRecorder()
+	setupRouter().ServeHTTP(w, req)
+
+	body, _ := io.ReadAll(w.Body)
+	start := strings.LastIndex(string(body), "<script>") + len("<script>")
+	end := strings.LastIndex(string(body), "</script>")
+	if hash := scriptHash(string(body[start:end])); !strings.Contains(w.Header().Get("Content-Security-Policy"), "'"+hash+"'") {
+		t.Errorf("inline script hash %s is not allowed by %s", hash, w.Header().Get("Content-Security-Policy"))
+	}
+}
+
+func TestCORS(t *testing.T) {
+	withAuth(t, testAuthConfig())
+	withSecurity(t, securityConfig{allowedOrigins: []string{"https://partner.example.com"}})
+	r := setupRouter()
+
+	// プリフライトは認証なしで 204
+	req, _ := http.NewRequest("OPTIONS", "/api/v1/events", nil)
+	req.Header.Set("Origin", "https://partner.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type, x-api-key")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://partner.example.com" ||
+		!strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), "X-API-Key") || !strings.Contains(w.Header().Get("Access-Control-Allow-Methods"), "POST") {
+		t.Errorf("unexpected preflight response %d %v", w.Code, w.Header())
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/prefectures", nil)
+	req.Header.Set("Origin", "https://partner.example.com")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://partner.example.com" || !strings.Contains(w.Header().Get("Access-Control-Expose-Headers"), "Link") {
+		t.Errorf("unexpected CORS headers %v", w.Header())
+	}
+	if vary := w.Header().Values("Vary"); !strings.Contains(strings.Join(vary, ","), "Origin") {
+		t.Errorf("Vary = %v", vary)
+	}
+
+	// 許可していないオリジン
+	req, _ = http.NewRequest("OPTIONS", "/api/v1/events", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code == http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected the origin to be rejected, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	withSecurity(t, securityConfig{trustedProxies: []string{"10.0.0.0/8"}})
+	l, _ := withRateLimit(t, 1, 30)
+	r := setupRouter()
+
+	for _, remote := range []string{"10.1.2.3:5000", "198.51.100.1:5000"} {
+		req, _ := http.NewRequest("GET", "/api/v1/prefectures", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	clients := map[string]bool{}
+	for _, u := range l.Usage() {
+		clients[u.Client] = true
+	}
+	// プロキシ経由は転送元の IP、それ以外は X-Forwarded-For を無視する
+	if !clients["ip:203.0.113.7"] || !clients["ip:198.51.100.1"] || len(clients) != 2 {
+		t.Errorf("unexpected clients %v", clients)
+	}
+}
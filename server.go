@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// 停止時に処理中のリクエスト (取り込みを含む) の完了を待つ時間
+const defaultShutdownTimeout = 30 * time.Second
+
+// -------------
+// サーバーの起動・停止
+// -------------
+
+type lifecycleHook struct {
+	Name string
+	Fn   func(ctx context.Context) error
+}
+
+// シグナルで停止する HTTP サーバー
+type server struct {
+	http            *http.Server
+	shutdownTimeout time.Duration
+	startHooks      []lifecycleHook
+	stopHooks       []lifecycleHook
+}
+
+func newServer(addr string, handler http.Handler) *server {
+	timeout := defaultShutdownTimeout
+	if s := os.Getenv("SHUTDOWN_TIMEOUT"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			timeout = d
+		} else {
+			logger.Warn("invalid SHUTDOWN_TIMEOUT", "value", s, "error", err)
+		}
+	}
+	return &server{
+		http:            &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second},
+		shutdownTimeout: timeout,
+	}
+}
+
+// PORT 環境変数 (gin の Run と同じ、省略時は 8080)
+func listenAddr() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":8080"
+}
+
+// リクエストを受け付ける前に登録順に実行する。エラーの場合は起動しない
+func (s *server) OnStart(name string, fn func(ctx context.Context) error) {
+	s.startHooks = append(s.startHooks, lifecycleHook{name, fn})
+}
+
+// 処理中のリクエストが終わった後に登録と逆順に実行する
+func (s *server) OnStop(name string, fn func(ctx context.Context) error) {
+	s.stopHooks = append(s.stopHooks, lifecycleHook{name, fn})
+}
+
+func (s *server) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// ctx が終了するまでリクエストを受け付け、終了したら処理中のリクエストを待って停止する
+func (s *server) Serve(ctx context.Context, ln net.Listener) error {
+	for _, h := range s.startHooks {
+		if err := h.Fn(ctx); err != nil {
+			ln.Close()
+			return errors.New("start hook " + h.Name + ": " + err.Error())
+		}
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("listening", "addr", ln.Addr().String())
+		serveErr <- s.http.Serve(ln)
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		logger.Info("shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+		defer cancel()
+		if err = s.http.Shutdown(shutdownCtx); err != nil {
+			// 待ちきれなかったリクエストは接続を切る (取り込みはロールバックされる)
+			logger.Warn("graceful shutdown failed", "error", err)
+			s.http.Close()
+		}
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	for i := len(s.stopHooks) - 1; i >= 0; i-- {
+		h := s.stopHooks[i]
+		if hookErr := h.Fn(stopCtx); hookErr != nil {
+			logger.Warn("stop hook failed", "hook", h.Name, "error", hookErr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServerGracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	s := newServer("", mux)
+	var calls []string
+	s.OnStart("db", func(ctx context.Context) error { calls = append(calls, "start db"); return nil })
+	s.OnStart("scheduler", func(ctx context.Context) error { calls = append(calls, "start scheduler"); return nil })
+	s.OnStop("db", func(ctx context.Context) error { calls = append(calls, "stop db"); return nil })
+	s.OnStop("scheduler", func(ctx context.Context) error { calls = append(calls, "stop scheduler"); return nil })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ctx, ln) }()
+
+	// 処理中のリクエストは停止の指示の後も完了する
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+	<-started
+	cancel()
+
+	if got := <-body; got != "done" {
+		t.Errorf("in-flight request got %q", got)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve returned %v", err)
+	}
+	expected := []string{"start db", "start scheduler", "stop scheduler", "stop db"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("hooks ran in order %v", calls)
+	}
+
+	// 停止後は接続できない
+	if _, err := http.Get("http://" + ln.Addr().String() + "/slow"); err == nil {
+		t.Error("server still accepts requests")
+	}
+}
+
+func TestServerStartHookError(t *testing.T) {
+	s := newServer("", http.NewServeMux())
+	s.OnStart("db", func(ctx context.Context) error { return errors.New("connection refused") })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Serve(context.Background(), ln); err == nil || err.Error() != "start hook db: connection refused" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
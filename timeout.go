@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// REQUEST_TIMEOUT を指定しない場合の期限
+const defaultRouteTimeout = 10 * time.Second
+
+// -------------
+// リクエストの期限
+// -------------
+
+// ROUTE_TIMEOUTS を指定しない場合のルート (gin のパス) ごとの期限
+var defaultRouteTimeouts = map[string]time.Duration{
+	// オープンデータの取得と全件の入れ替え
+	"/import":                    5 * time.Minute,
+	"/importmedical":             5 * time.Minute,
+	"/api/v1/imports/infections": 5 * time.Minute,
+	"/api/v1/imports/medical":    5 * time.Minute,
+	// 描画に時間がかかる
+	"/chart/:place":                   30 * time.Second,
+	"/api/v1/prefectures/:code/chart": 30 * time.Second,
+}
+
+// ルートごとの期限を指定しない場合の期限
+var requestTimeout = defaultRouteTimeout
+
+// ルートごとの期限。期限を過ぎると DB・オープンデータへの問い合わせを中断して 504 を返す
+var routeTimeouts = defaultRouteTimeouts
+
+// REQUEST_TIMEOUT (例: "15s") で既定の期限を、
+// ROUTE_TIMEOUTS (例: "/api/v1/imports/infections=10m,/api/v1/aggregate=20s") でルートごとの期限を変える。
+// ROUTE_TIMEOUTS に無いルートは defaultRouteTimeouts の期限のまま
+func configureTimeouts() error {
+	timeout := defaultRouteTimeout
+	if s := os.Getenv("REQUEST_TIMEOUT"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid REQUEST_TIMEOUT %q", s)
+		}
+		timeout = d
+	}
+
+	timeouts := make(map[string]time.Duration, len(defaultRouteTimeouts))
+	for route, d := range defaultRouteTimeouts {
+		timeouts[route] = d
+	}
+	if s := os.Getenv("ROUTE_TIMEOUTS"); s != "" {
+		for _, entry := range strings.Split(s, ",") {
+			route, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			d, err := time.ParseDuration(strings.TrimSpace(value))
+			route = strings.TrimSpace(route)
+			if !ok || !strings.HasPrefix(route, "/") || err != nil || d <= 0 {
+				return fmt.Errorf("invalid ROUTE_TIMEOUTS entry %q", entry)
+			}
+			timeouts[route] = d
+		}
+	}
+
+	requestTimeout, routeTimeouts = timeout, timeouts
+	return nil
+}
+
+// リクエストのコンテキストに期限を付ける。クライアントが切断した場合もコンテキストが終了する
+func timeoutMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout, ok := routeTimeouts[c.FullPath()]
+		if !ok {
+			timeout = requestTimeout
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// コンテキストが終了したら中断する GET リクエスト
+func fetch(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	routeTimeouts["/slow"] = 10 * time.Millisecond
+	routeTimeouts["/upstream"] = 10 * time.Millisecond
+	defer delete(routeTimeouts, "/slow")
+	defer delete(routeTimeouts, "/upstream")
+
+	r := gin.New()
+	r.Use(errorMiddleware())
+	r.Use(timeoutMiddleware())
+	r.GET("/slow", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		// ドライバーによっては期限切れが別のエラーになる
+		abortWithError(c, errors.New("invalid connection"))
+	})
+	r.GET("/upstream", func(c *gin.Context) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+		defer srv.Close()
+		if _, err := fetch(c.Request.Context(), srv.URL); err != nil {
+			abortWithError(c, errUpstream(err))
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	r.GET("/fast", func(c *gin.Context) {
+		deadline, ok := c.Request.Context().Deadline()
+		if !ok || time.Until(deadline) > defaultRouteTimeout {
+			t.Errorf("unexpected deadline %v", deadline)
+		}
+		c.Status(http.StatusOK)
+	})
+
+	for _, path := range []string{"/slow", "/upstream"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusGatewayTimeout {
+			t.Errorf("%s: expected status %d, got %d", path, http.StatusGatewayTimeout, w.Code)
+			continue
+		}
+		var body struct {
+			Error apiError `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Code != codeTimeout {
+			t.Errorf("%s: unexpected body %s", path, w.Body.String())
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/fast", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestConfigureTimeouts(t *testing.T) {
+	defer func(timeout time.Duration, timeouts map[string]time.Duration) {
+		requestTimeout, routeTimeouts = timeout, timeouts
+	}(requestTimeout, routeTimeouts)
+
+	t.Setenv("REQUEST_TIMEOUT", "15s")
+	t.Setenv("ROUTE_TIMEOUTS", " /api/v1/imports/infections=10m , /api/v1/aggregate=20s")
+	if err := configureTimeouts(); err != nil {
+		t.Fatal(err)
+	}
+	if requestTimeout != 15*time.Second {
+		t.Errorf("unexpected default timeout %v", requestTimeout)
+	}
+	expected := map[string]time.Duration{
+		"/api/v1/imports/infections":      10 * time.Minute,
+		"/api/v1/aggregate":               20 * time.Second,
+		"/api/v1/imports/medical":         5 * time.Minute, // 指定しないルートは既定のまま
+		"/api/v1/prefectures/:code/chart": 30 * time.Second,
+	}
+	for route, d := range expected {
+		if routeTimeouts[route] != d {
+			t.Errorf("%s: expected %v, got %v", route, d, routeTimeouts[route])
+		}
+	}
+	if defaultRouteTimeouts["/api/v1/imports/infections"] != 5*time.Minute {
+		t.Error("defaults must not be modified")
+	}
+
+	for _, tt := range []struct{ timeout, routes string }{
+		{"10", ""},
+		{"-1s", ""},
+		{"", "/api/v1/aggregate"},
+		{"", "api/v1/aggregate=20s"},
+		{"", "/api/v1/aggregate=0s"},
+	} {
+		t.Setenv("REQUEST_TIMEOUT", tt.timeout)
+		t.Setenv("ROUTE_TIMEOUTS", tt.routes)
+		if err := configureTimeouts(); err == nil {
+			t.Errorf("expected an error for %q %q", tt.timeout, tt.routes)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/hikobend/corona"
+
+// W3C Trace Context (traceparent ヘッダー) で呼び出し元のトレースを引き継ぐ
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// -------------
+// OpenTelemetry のトレース
+// -------------
+
+// OTEL_TRACES_EXPORTER で出力先を選ぶ
+//   - otlp: OTEL_EXPORTER_OTLP_ENDPOINT (省略時は localhost:4318) のコレクターへ送る
+//   - stdout: 標準出力に JSON で出す (ローカルでの調査用)
+//   - none (省略時): 出力しない
+//
+// 戻り値の関数で未送信の span を送って終了する
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := os.Getenv("OTEL_TRACES_EXPORTER"); name {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	default:
+		err = errors.New("unknown OTEL_TRACES_EXPORTER " + name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME / OTEL_RESOURCE_ATTRIBUTES があればそちらを優先する
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("corona")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// 設定されている TracerProvider から取得する (未設定の場合は何もしない)
+func tracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(tracerName)
+}
+
+// リクエストごとの span。traceparent ヘッダーがあれば呼び出し元のトレースにつなげる
+func tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		name := c.Request.Method + " " + route
+		if route == "" {
+			name = c.Request.Method // 存在しない URL は span 名にパスを使わない
+		}
+		ctx, span := tracer().Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+			))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if last := c.Errors.Last(); last != nil {
+			span.RecordError(last.Err)
+		}
+		if status >= 500 {
+			span.SetStatus(codes.Error, "")
+		}
+	}
+}
+
+// -------------
+// SQL の span (instrumentedDriver から呼ぶ)
+// -------------
+
+func startQuerySpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	operation, table := queryLabels(query)
+	return tracer().Start(ctx, operation+" "+table,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemMySQL,
+			semconv.DBStatement(query),
+			semconv.DBOperation(operation),
+			semconv.DBSQLTable(table),
+		))
+}
+
+// クエリの終了時に defer で呼ぶ。所要時間のメトリクスもここで記録する
+func endQuery(span trace.Span, query string, start time.Time, err *error) {
+	observeQuery(query, start)
+	if *err != nil && !errors.Is(*err, driver.ErrSkip) {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+// -------------
+// 取り込みの段階 (fetch / decode / write) ごとの span
+// -------------
+
+type importTrace struct {
+	c      *gin.Context
+	parent context.Context
+	span   trace.Span
+}
+
+func newImportTrace(c *gin.Context) *importTrace {
+	return &importTrace{c: c, parent: c.Request.Context()}
+}
+
+// 前の段階を終了して次の段階を開始する。段階中の DB 呼び出しはこの span の下になる
+func (t *importTrace) Phase(name string) {
+	t.end()
+	ctx, span := tracer().Start(t.parent, "import."+name)
+	t.span = span
+	t.c.Request = t.c.Request.WithContext(ctx)
+}
+
+// 取り込みの最後に defer で呼ぶ。中断した場合はその段階をエラーにする
+func (t *importTrace) End() {
+	t.end()
+	t.c.Request = t.c.Request.WithContext(t.parent)
+}
+
+func (t *importTrace) end() {
+	if t.span == nil {
+		return
+	}
+	if t.c.IsAborted() {
+		if last := t.c.Errors.Last(); last != nil {
+			t.span.RecordError(last.Err)
+		}
+		t.span.SetStatus(codes.Error, "")
+	}
+	t.span.End()
+	t.span = nil
+}
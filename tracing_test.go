@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// テストの間だけ span を記録する TracerProvider にする
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	sr := tracetest.NewSpanRecorder()
+	saved := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	t.Cleanup(func() { otel.SetTracerProvider(saved) })
+	return sr
+}
+
+func spanAttr(s sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	sr := recordSpans(t)
+	r := setupRouter()
+
+	req, _ := http.NewRequest("GET", "/api/v1/prefectures", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := sr.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	s := spans[0]
+	if s.Name() != "GET /api/v1/prefectures" || s.SpanKind() != trace.SpanKindServer {
+		t.Errorf("unexpected span %s (%v)", s.Name(), s.SpanKind())
+	}
+	if got := s.Parent().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("span is not linked to the caller's trace: %s", got)
+	}
+	if got := spanAttr(s, "http.response.status_code").AsInt64(); got != http.StatusOK {
+		t.Errorf("status attribute = %d", got)
+	}
+}
+
+func TestQuerySpan(t *testing.T) {
+	sr := recordSpans(t)
+	query := "select date, name_jp, npatients from infection where date between ? and ?"
+
+	var err error
+	_, span := startQuerySpan(context.Background(), query)
+	endQuery(span, query, time.Now(), &err)
+	err = errors.New("connection reset")
+	_, span = startQuerySpan(context.Background(), query)
+	endQuery(span, query, time.Now(), &err)
+	err = driver.ErrSkip
+	_, span = startQuerySpan(context.Background(), query)
+	endQuery(span, query, time.Now(), &err)
+
+	spans := sr.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	if spans[0].Name() != "select infection" || spanAttr(spans[0], "db.statement").AsString() != query {
+		t.Errorf("unexpected span %s", spans[0].Name())
+	}
+	for i, want := range []codes.Code{codes.Unset, codes.Error, codes.Unset} {
+		if got := spans[i].Status().Code; got != want {
+			t.Errorf("span %d: status = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestImportTrace(t *testing.T) {
+	sr := recordSpans(t)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("POST", "/import", nil)
+	parent := c.Request.Context()
+
+	phases := newImportTrace(c)
+	phases.Phase("fetch")
+	fetchSpan := trace.SpanFromContext(c.Request.Context()).SpanContext()
+	phases.Phase("decode")
+	abortWithError(c, errUpstream(errors.New("unexpected end of JSON input")))
+	phases.End()
+
+	spans := sr.Ended()
+	if len(spans) != 2 || spans[0].Name() != "import.fetch" || spans[1].Name() != "import.decode" {
+		t.Fatalf("unexpected spans %v", spans)
+	}
+	if spans[0].SpanContext().SpanID() != fetchSpan.SpanID() {
+		t.Error("fetch phase was not set on the request context")
+	}
+	if spans[0].Status().Code != codes.Unset || spans[1].Status().Code != codes.Error {
+		t.Errorf("unexpected status %v, %v", spans[0].Status(), spans[1].Status())
+	}
+	if c.Request.Context() != parent {
+		t.Error("request context was not restored")
+	}
+}